@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// checkTimeout bounds how long one conformance check waits for its replies.
+const checkTimeout = 5 * time.Second
+
+// check sends raw request lines (version included, so version handling can
+// be tested too) and expects one reply per entry in want. A want ending in
+// "*" matches any reply with that prefix; otherwise the match is exact.
+type check struct {
+	name string
+	send []string
+	want []string
+}
+
+// conformanceChecks covers the commands a server answers with default flags.
+// Everything lives under prefix p so a shared server's data is left alone;
+// PAUSE, RESTART, DEBUG and friends are skipped because they are either
+// flag-gated or affect other clients.
+func conformanceChecks(p string) []check {
+	v := func(line string) string { return Version + " " + line }
+	return []check{
+		{"unknown command", []string{v("NOSUCHCMD")}, []string{"400 BAD_REQUEST"}},
+		{"missing version", []string{"GET " + p + "k"}, []string{"426 UPGRADE_REQUIRED"}},
+		{"wrong version", []string{"KV/9.9 GET " + p + "k"}, []string{"426 UPGRADE_REQUIRED"}},
+		{"lowercase verb", []string{v("get " + p + "k")}, []string{"404 NOT_FOUND"}},
+
+		{"PUT arity", []string{v("PUT " + p + "k")}, []string{"400 BAD_REQUEST"}},
+		{"PUT create then update", []string{v("PUT " + p + "k v1"), v("PUT " + p + "k v2")}, []string{"201 CREATED", "200 OK"}},
+		{"GET arity", []string{v("GET")}, []string{"400 BAD_REQUEST"}},
+		{"GET hit", []string{v("GET " + p + "k")}, []string{"200 OK v2"}},
+		{"GET miss", []string{v("GET " + p + "missing")}, []string{"404 NOT_FOUND"}},
+		{"DEL hit then miss", []string{v("DEL " + p + "k"), v("DEL " + p + "k")}, []string{"204 NO_CONTENT", "404 NOT_FOUND"}},
+
+		{"PUTCHANGED", []string{v("PUTCHANGED " + p + "c 1"), v("PUTCHANGED " + p + "c 1")}, []string{"200 OK 1", "200 OK 0"}},
+		{"PUTSEQ", []string{v("PUTSEQ " + p + "seq x")}, []string{"201 CREATED " + p + "seq:*"}},
+		{"GETEXISTING", []string{v("GETEXISTING " + p + "c " + p + "missing")}, []string{`200 OK {"` + p + `c":"1"}`}},
+		{"GETPREFIX", []string{v("GETPREFIX " + p + "c")}, []string{`200 OK {"items":{"` + p + `c":"1"},"truncated":false}`}},
+		{"GETRANGE", []string{v("GETRANGE " + p + "c 0 -1"), v("GETRANGE " + p + "missing 0 1")}, []string{"200 OK 1", "404 NOT_FOUND"}},
+		{"SETRANGE", []string{v("SETRANGE " + p + "c 1 zz"), v("GET " + p + "c")}, []string{"200 OK 3", "200 OK 1zz"}},
+		{"GETRESET", []string{v("PUT " + p + "n 5"), v("GETRESET " + p + "n"), v("GET " + p + "n")}, []string{"201 CREATED", "200 OK 5", "200 OK 0"}},
+		{"GETRESET non-integer", []string{v("GETRESET " + p + "c")}, []string{"422 NOT_AN_INTEGER"}},
+		{"CADEL", []string{v("CADEL " + p + "n 1"), v("CADEL " + p + "n 0"), v("CADEL " + p + "n 0")}, []string{"409 CONFLICT", "204 NO_CONTENT", "404 NOT_FOUND"}},
+		{"PUTIF", []string{v("PUTIF " + p + "c nope " + p + "d 1"), v("PUTIF " + p + "c 1zz " + p + "d 1")}, []string{"409 CONFLICT", "200 OK"}},
+		{"WIPE", []string{v("WIPE " + p + "d"), v("WIPE " + p + "d")}, []string{"204 NO_CONTENT", "404 NOT_FOUND"}},
+		{"COUNT", []string{v("COUNT " + p + "c*"), v("COUNT [")}, []string{"200 OK 1", "400 BAD_REQUEST"}},
+
+		{"BIGKEYS", []string{v("BIGKEYS 1"), v("BIGKEYS 0")}, []string{"200 OK [*", "400 BAD_REQUEST"}},
+		{"CARDINALITY", []string{v("CARDINALITY :")}, []string{"200 OK {*"}},
+		{"CHECKSUM", []string{v("CHECKSUM " + p)}, []string{"200 OK *"}},
+		{"STATS", []string{v("STATS")}, []string{"200 OK {*"}},
+		{"SERVERINFO", []string{v("SERVERINFO")}, []string{"200 OK {*"}},
+		{"TIME", []string{v("TIME")}, []string{"200 OK *"}},
+		{"READONLY", []string{v("READONLY"), v("PUT " + p + "ro x"), v("GET " + p + "c"), v("READWRITE")}, []string{"200 OK", "403 READONLY", "200 OK 1zz", "200 OK"}},
+
+		{"BATCH", []string{v("BATCH 2"), v("PUT " + p + "b 1"), v("GET " + p + "b")}, []string{"201 CREATED", "200 OK 1", `200 OK {"atomic":false,"count":2,"failed":0,"ok":2}`}},
+		{"BATCH ATOMIC", []string{v("BATCH 1 ATOMIC"), v("GET " + p + "b")}, []string{"200 OK 1", `200 OK {"atomic":true,"count":1,"failed":0,"ok":1}`}},
+		{"BATCH nested", []string{v("BATCH 1"), v("BATCH 1")}, []string{"400 BAD_REQUEST", `200 OK {"atomic":false,"count":1,"failed":1,"ok":0}`}},
+		{"QUIET", []string{v("QUIET ON"), v("PUT " + p + "q 1"), v("GET " + p + "missing"), v("QUIET OFF"), v("DEL " + p + "q")}, []string{"200 OK", "404 NOT_FOUND", `200 OK {"errors":1,"suppressed":1}`, "204 NO_CONTENT"}},
+		{"BATCH arity", []string{v("BATCH")}, []string{"400 BAD_REQUEST"}},
+
+		{"cleanup", []string{v("DEL " + p + "c"), v("DEL " + p + "seq:1"), v("DEL " + p + "b")}, []string{"204 NO_CONTENT", "204 NO_CONTENT", "204 NO_CONTENT"}},
+	}
+}
+
+func matches(got, want string) bool {
+	if prefix, ok := strings.CutSuffix(want, "*"); ok {
+		return strings.HasPrefix(got, prefix)
+	}
+	return got == want
+}
+
+// runConformance runs every check over conn, prints a PASS/FAIL line per
+// check and a summary, and returns the process exit code. A check that
+// times out or loses the connection fails, and the rest are not attempted
+// since the reply stream can no longer be trusted.
+func runConformance(conn net.Conn) int {
+	p := fmt.Sprintf("conformance:%d:", time.Now().UnixNano())
+	rc := bufio.NewReader(conn)
+	passed, failed := 0, 0
+	checks := conformanceChecks(p)
+	for i, c := range checks {
+		_ = conn.SetDeadline(time.Now().Add(checkTimeout))
+		if _, err := conn.Write([]byte(strings.Join(c.send, "\n") + "\n")); err != nil {
+			fmt.Printf("FAIL %s: write: %v\n", c.name, err)
+			failed += len(checks) - i
+			break
+		}
+		var problem string
+		var broken bool
+		for _, want := range c.want {
+			line, err := rc.ReadString('\n')
+			if err != nil {
+				problem, broken = fmt.Sprintf("read: %v", err), true
+				break
+			}
+			got := strings.TrimRight(line, "\r\n")
+			if problem == "" && !matches(got, want) {
+				problem = fmt.Sprintf("want %q, got %q", want, got)
+			}
+		}
+		if problem == "" {
+			fmt.Printf("PASS %s\n", c.name)
+			passed++
+			continue
+		}
+		fmt.Printf("FAIL %s: %s\n", c.name, problem)
+		failed++
+		if broken {
+			failed += len(checks) - i - 1
+			break
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strings"
+	"time"
 )
 
 const (
@@ -14,50 +18,138 @@ const (
 )
 
 func main() {
+	network := flag.String("network", "tcp", "dial network: tcp, tcp4 or tcp6")
+	connectTimeout := flag.Duration("connect-timeout", 10*time.Second, "give up connecting (including DNS) after this long")
+	noDelay := flag.Bool("tcp-nodelay", true, "disable Nagle's algorithm (false batches small writes for throughput)")
+	conformance := flag.Bool("conformance", false, "run protocol conformance checks against the server and exit non-zero on any failure")
+	flag.Parse()
+
+	switch *network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		fmt.Println("invalid -network:", *network)
+		os.Exit(2)
+	}
 	addr := DefaultAddr
-	if len(os.Args) > 1 && os.Args[1] != "" {
-		addr = os.Args[1]
+	if flag.NArg() > 0 && flag.Arg(0) != "" {
+		addr = flag.Arg(0)
 	}
 
-	conn, err := net.Dial("tcp", addr)
+	conn, err := net.DialTimeout(*network, addr, *connectTimeout)
 	if err != nil {
 		fmt.Println("connect error:", err)
 		os.Exit(1)
 	}
 	defer conn.Close()
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetNoDelay(*noDelay)
+	}
+	if *conformance {
+		code := runConformance(conn)
+		conn.Close()
+		os.Exit(code)
+	}
+
+	// Piped input: no prompts, and wait for every response before exiting.
+	interactive := true
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+		interactive = false
+	}
 
 	fmt.Printf("[KVSS Client] connected %s\n", addr)
 	fmt.Println(`Type commands without version.....`)
 
+	session(conn, os.Stdin, os.Stdout, interactive)
+}
+
+// session sends each line of in to the server and prints every reply to
+// out. When in is piped it returns only after every reply has arrived; if
+// the server hangs up first it returns as soon as that is noticed.
+func session(conn net.Conn, in io.Reader, out io.Writer, interactive bool) {
+	closed := make(chan struct{})
+	inputDone := make(chan struct{})
+	var replies replyTracker
 	go func() {
 		// reader for server responses
 		rc := bufio.NewReader(conn)
 		for {
 			line, err := rc.ReadString('\n')
 			if err != nil {
-				fmt.Println("[server closed]")
-				os.Exit(0)
+				// ErrClosed: we closed it ourselves on the way out
+				if !errors.Is(err, net.ErrClosed) {
+					fmt.Fprintln(out, "[server closed]")
+				}
+				close(closed)
+				return
+			}
+			fmt.Fprint(out, "[resp] ", line)
+			if warn := replies.see(line); warn != "" {
+				fmt.Fprintln(out, "[warn]", warn)
 			}
-			fmt.Print("[resp] ", line)
 		}
 	}()
 
-	// stdin loop
-	sc := bufio.NewScanner(os.Stdin)
+	go func() {
+		defer close(inputDone)
+		writeInput(conn, in, out, interactive, &replies)
+	}()
+	select {
+	case <-inputDone:
+	case <-closed:
+	}
+}
+
+// writeInput is the stdin loop: it sends each line with the version
+// prepended, registering the replies it expects first.
+func writeInput(conn net.Conn, in io.Reader, out io.Writer, interactive bool, replies *replyTracker) {
+	quiet := false
+	sc := bufio.NewScanner(in)
 	for {
-		fmt.Print("> ")
+		if interactive {
+			fmt.Fprint(out, "> ")
+		}
 		if !sc.Scan() {
-			return
+			break
 		}
 		line := strings.TrimSpace(sc.Text())
 		if line == "" {
 			continue
 		}
+		switch f := strings.Fields(strings.ToUpper(line)); {
+		case len(f) == 2 && f[0] == "QUIET" && f[1] == "OFF":
+			if quiet {
+				replies.expect(replyQuietOff)
+			} else {
+				replies.expect(replyOne)
+			}
+			quiet = false
+		case len(f) == 2 && f[0] == "QUIET" && f[1] == "ON":
+			if !quiet {
+				replies.expect(replyOne)
+			}
+			quiet = true
+		case !quiet:
+			replies.expect(replyOne)
+		}
 		// prepend version
 		msg := Version + " " + line + "\n"
 		if _, err := conn.Write([]byte(msg)); err != nil {
-			fmt.Println("write error:", err)
+			fmt.Fprintln(out, "write error:", err)
 			return
 		}
 	}
+
+	// every request gets exactly one response line, bar those hidden by
+	// QUIET; if the server hangs up first, session stops waiting
+	if !interactive {
+		if quiet {
+			// lấy summary để biết đã nhận hết lỗi
+			replies.expect(replyQuietOff)
+			if _, err := conn.Write([]byte(Version + " QUIET OFF\n")); err != nil {
+				fmt.Fprintln(out, "write error:", err)
+				return
+			}
+		}
+		replies.wait()
+	}
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer lets the reader and writer goroutines share one output.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+// slowServer answers each request line with "200 OK <n>" after a delay, so
+// replies are still in flight when the client's input runs out.
+func slowServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		sc := bufio.NewScanner(c)
+		for n := 1; sc.Scan(); n++ {
+			time.Sleep(time.Millisecond)
+			fmt.Fprintf(c, "200 OK %d\n", n)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// Piped input must not exit before every reply is printed.
+func TestSessionPipedWaitsForAllReplies(t *testing.T) {
+	conn, err := net.Dial("tcp", slowServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const n = 200
+	var in strings.Builder
+	for i := range n {
+		fmt.Fprintf(&in, "PUT k%d v\n", i)
+	}
+	var out syncBuffer
+	session(conn, strings.NewReader(in.String()), &out, false)
+
+	got := out.String()
+	for i := 1; i <= n; i++ {
+		if !strings.Contains(got, fmt.Sprintf("[resp] 200 OK %d\n", i)) {
+			t.Fatalf("reply %d missing from output", i)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type replyKind int
+
+const (
+	replyOne      replyKind = iota // exactly one reply line
+	replyQuietOff                  // error lines, then the QUIET OFF summary
+)
+
+// replyTracker matches reply lines to the requests that caused them, in
+// send order, so piped input knows when everything has been answered.
+// While QUIET is on the server only sends errors, so requests sent then
+// expect nothing; the QUIET OFF closing the run expects those errors
+// followed by its summary, the only 2xx line of the run. Reply content is
+// never used to tell replies apart, since a value can look like anything.
+type replyTracker struct {
+	mu        sync.Mutex
+	queue     []replyKind
+	quietErrs int // error lines seen since the last summary
+	pending   sync.WaitGroup
+}
+
+// expect registers one request's reply; call it before the request is
+// written so the reader can't see the reply first.
+func (t *replyTracker) expect(k replyKind) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queue = append(t.queue, k)
+	t.pending.Add(1)
+}
+
+// see accounts for one reply line. It returns a warning if a QUIET OFF
+// summary is unreadable or disagrees with the errors actually received.
+func (t *replyTracker) see(line string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	isErr := !strings.HasPrefix(line, "2")
+	if len(t.queue) == 0 || t.queue[0] == replyQuietOff && isErr {
+		// an error from inside a QUIET run
+		if isErr {
+			t.quietErrs++
+		}
+		return ""
+	}
+	k := t.queue[0]
+	t.queue = t.queue[1:]
+	defer t.pending.Done()
+	if k != replyQuietOff {
+		return ""
+	}
+
+	seen := t.quietErrs
+	t.quietErrs = 0
+	var sum struct {
+		Errors     int `json:"errors"`
+		Suppressed int `json:"suppressed"`
+	}
+	body, _ := strings.CutPrefix(strings.TrimSpace(line), "200 OK ")
+	if err := json.Unmarshal([]byte(body), &sum); err != nil {
+		return fmt.Sprintf("unreadable QUIET OFF summary: %v", err)
+	}
+	if sum.Errors != seen {
+		return fmt.Sprintf("QUIET OFF reports %d errors, received %d", sum.Errors, seen)
+	}
+	return ""
+}
+
+// wait blocks until every expected reply has been seen.
+func (t *replyTracker) wait() {
+	t.pending.Wait()
+}
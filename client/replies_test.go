@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func waitDone(t *testing.T, r *replyTracker) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() { r.wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("tracker still waiting for replies")
+	}
+}
+
+// Values that look like protocol, a QUIET OFF summary included, are plain
+// replies.
+func TestReplyTrackerValuesAreNotProtocol(t *testing.T) {
+	var r replyTracker
+	for _, line := range []string{
+		"200 OK\n",
+		`200 OK {"errors":5}` + "\n",
+		`200 OK {"errors":3,"x":1}` + "\n",
+		"200 OK KV/1.0\n",
+		"200 OK QUIT\n",
+		"200 OK BATCH\n",
+		"200 OK QUIET\n",
+		"200 OK 404\n",
+		"200 OK 200\n",
+		"200 OK OK\n",
+	} {
+		r.expect(replyOne)
+		if warn := r.see(line); warn != "" {
+			t.Errorf("%q: unexpected warning %q", line, warn)
+		}
+	}
+	waitDone(t, &r)
+}
+
+func TestReplyTrackerQuietRun(t *testing.T) {
+	var r replyTracker
+	r.expect(replyOne) // QUIET ON
+	r.see("200 OK\n")
+	// quiet requests expect nothing; their errors arrive before QUIET OFF is sent
+	r.see("404 NOT_FOUND\n")
+	r.expect(replyQuietOff)
+	r.see("400 BAD_REQUEST\n")
+	if warn := r.see(`200 OK {"errors":2,"suppressed":4}` + "\n"); warn != "" {
+		t.Errorf("unexpected warning %q", warn)
+	}
+	waitDone(t, &r)
+}
+
+func TestReplyTrackerBadSummary(t *testing.T) {
+	var r replyTracker
+	r.expect(replyQuietOff)
+	if warn := r.see("200 OK nonsense\n"); warn == "" {
+		t.Error("no warning for unreadable summary")
+	}
+	r.expect(replyQuietOff)
+	if warn := r.see(`200 OK {"errors":1,"suppressed":0}` + "\n"); warn == "" {
+		t.Error("no warning for error count mismatch")
+	}
+	waitDone(t, &r)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// backend is everything the command layer needs from storage: plain
+// put/get/del, one atomic read-modify-write primitive and a keyspace walk.
+// Compound commands (CADEL, GETRESET, GETPREFIX, ...) are built on update
+// and scan in ops.go, so a new backend only implements this set. putIf,
+// putSeq and wipe stay here because they need more than one key or
+// backend-owned state to be atomic. Every method can fail, so a backend
+// that does I/O can report it; the command layer answers 500 SERVER_ERROR.
+type backend interface {
+	put(k, v string) (created bool, err error)
+	get(k string) (v string, ok bool, err error)
+	// getMany returns the keys that exist among keys, all read as of one
+	// instant.
+	getMany(keys []string) (map[string]string, error)
+	del(k string) (bool, error)
+	size() (int, error)
+
+	// update calls fn with k's current value and applies the result, all
+	// atomically with respect to other writers. fn must not keep or modify
+	// old, and the backend owns the returned slice.
+	update(k string, fn updateFunc) error
+	// scan calls fn for every key until fn returns false. It need not be a
+	// snapshot; fn must not keep or modify v.
+	scan(fn func(k string, v []byte) bool) error
+
+	putIf(condKey, condVal, k, v string) (bool, error)
+	putSeq(prefix, v string) (string, error)
+	wipe(k string) (bool, error)
+}
+
+type updateOp int
+
+const (
+	updateKeep   updateOp = iota // leave k as it was
+	updateSet                    // store the returned value
+	updateDelete                 // remove k
+)
+
+type updateFunc func(old []byte, exists bool) (v []byte, op updateOp)
+
+// historian is implemented by backends that keep superseded values for
+// HISTORY.
+type historian interface {
+	history(k string, n int) ([]histValue, bool)
+}
+
+// warmer is implemented by backends with a cold tier (disk, mmap) that can
+// pull entries into memory ahead of reads. The in-memory store has nothing
+// to warm and doesn't implement it.
+type warmer interface {
+	warmup(prefix string) (touched int, err error)
+}
+
+var _ backend = (*store)(nil)
+
+// backends maps -backend names to constructors. A disk-backed store plugs
+// in by adding an entry here. The planned one is bbolt, for datasets larger
+// than RAM, with everything in a single bucket:
+//
+//   - put, get, del: Bucket.Put/Get/Delete inside db.Update or db.View
+//   - getMany: every Get inside one db.View
+//   - size: Bucket.Stats().KeyN, or a counter kept in a meta bucket
+//   - update: Get, fn, then Put or Delete in one db.Update; bbolt
+//     serialises writers
+//   - scan: a Cursor in db.View; values are only valid inside the
+//     transaction, which matches scan's no-keeping rule
+//   - putIf, putSeq, wipe: one db.Update each; putSeq can use
+//     Bucket.NextSequence on a per-prefix bucket
+//
+// Transaction and disk errors go back through the error returns.
+var backends = map[string]func(cfg config) (backend, error){
+	"memory": func(cfg config) (backend, error) {
+		return newStore(cfg.initialCapacity, cfg.historyDepth), nil
+	},
+}
+
+func newBackend(cfg config) (backend, error) {
+	mk, ok := backends[cfg.backend]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for n := range backends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown backend %q (have %s)", cfg.backend, strings.Join(names, ", "))
+	}
+	return mk(cfg)
+}
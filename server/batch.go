@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxBatch caps how many lines a single BATCH frame may carry.
+const maxBatch = 10000
+
+// maxBatchBytes caps the request bytes one BATCH frame may buffer. A frame
+// may always hold at least one line of -max-line-bytes.
+const maxBatchBytes = 16 << 20
+
+// isBatch reports whether line opens a BATCH frame, under whatever name
+// -rename-command gave it. A hidden BATCH goes on to dispatch, which
+// answers 404 UNKNOWN_COMMAND like any other disabled command.
+func (sv *server) isBatch(line string) bool {
+	toks := strings.Fields(line)
+	if len(toks) < 2 || toks[0] != Version {
+		return false
+	}
+	cmd, ok := sv.resolve(toks[1])
+	return ok && cmd == "BATCH"
+}
+
+// batch handles "KV/1.0 BATCH <n> [ATOMIC]": it reads the n command lines
+// that follow, runs them in order and returns their n responses followed by
+// a JSON summary line. All n lines are read before anything executes, so an
+// ATOMIC batch never holds execMu while waiting on the network. A frame
+// larger than maxBatchBytes runs nothing: its remaining lines are skipped
+// unbuffered and every line gets 413, keeping one reply per line. The error
+// is only non-nil when the connection dropped mid-frame.
+func (sv *server) batch(cs *connState, r *bufio.Reader, line string) (string, error) {
+	toks := strings.Fields(line)
+	if len(toks) < 3 || len(toks) > 4 {
+		return "400 BAD_REQUEST\n", nil
+	}
+	n, err := strconv.Atoi(toks[2])
+	if err != nil || n < 1 || n > maxBatch {
+		return "400 BAD_REQUEST\n", nil
+	}
+	atomic := false
+	if len(toks) == 4 {
+		if strings.ToUpper(toks[3]) != "ATOMIC" {
+			return "400 BAD_REQUEST\n", nil
+		}
+		atomic = true
+	}
+
+	lines := make([]string, n)
+	oversized := make(map[int]bool)
+	limit := max(maxBatchBytes, sv.cfg.maxLineBytes)
+	size := 0
+	for i := range lines {
+		if size > limit {
+			// limit 0: đọc bỏ cả dòng, không giữ lại gì
+			if _, err := readLine(r, 0); err != nil && !errors.Is(err, errLineTooLong) {
+				return "", err
+			}
+			continue
+		}
+		l, err := readLine(r, sv.cfg.maxLineBytes)
+		if errors.Is(err, errLineTooLong) {
+			oversized[i] = true
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		cs.traceIn(l)
+		lines[i] = cs.versioned(l)
+		size += len(l)
+	}
+	if size > limit {
+		sv.incr(&sv.stats.ReqCount, int64(n))
+		summary, _ := json.Marshal(map[string]any{
+			"count":  n,
+			"ok":     0,
+			"failed": n,
+			"atomic": atomic,
+		})
+		return strings.Repeat("413 PAYLOAD_TOO_LARGE\n", n) + fmt.Sprintf("200 OK %s\n", summary), nil
+	}
+
+	if atomic {
+		sv.execMu.Lock()
+		defer sv.execMu.Unlock()
+	} else {
+		sv.execMu.RLock()
+		defer sv.execMu.RUnlock()
+	}
+
+	var b strings.Builder
+	failed := 0
+	for i, l := range lines {
+		sv.incr(&sv.stats.ReqCount, 1)
+		var resp string
+		if oversized[i] {
+			resp = "413 PAYLOAD_TOO_LARGE\n"
+		} else if sv.isBatch(l) {
+			// a batch can't nest
+			resp = "400 BAD_REQUEST\n"
+		} else if r, quit := sv.dispatch(cs, l); quit {
+			// nor end the connection halfway through
+			resp = "400 BAD_REQUEST\n"
+		} else {
+			resp = r
+		}
+		if !strings.HasPrefix(resp, "2") {
+			failed++
+		}
+		b.WriteString(resp)
+	}
+
+	summary, _ := json.Marshal(map[string]any{
+		"count":  n,
+		"ok":     n - failed,
+		"failed": failed,
+		"atomic": atomic,
+	})
+	fmt.Fprintf(&b, "200 OK %s\n", summary)
+	return b.String(), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func runBatch(t *testing.T, sv *server, frame string) (resp, rest string) {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(frame))
+	first, err := readLine(r, sv.cfg.maxLineBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = sv.batch(&connState{}, r, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _ := readLine(r, sv.cfg.maxLineBytes)
+	return resp, next
+}
+
+func TestBatch(t *testing.T) {
+	sv := newTestServer(t, config{maxLineBytes: 1 << 10})
+	resp, _ := runBatch(t, sv, "KV/1.0 BATCH 2\nKV/1.0 PUT a 1\nKV/1.0 GET a\n")
+	want := "201 CREATED\n200 OK 1\n" + `200 OK {"atomic":false,"count":2,"failed":0,"ok":2}` + "\n"
+	if resp != want {
+		t.Errorf("got %q, want %q", resp, want)
+	}
+}
+
+// A frame past maxBatchBytes must run nothing, answer every line and leave
+// the reader at the request after the frame.
+func TestBatchTooLarge(t *testing.T) {
+	sv := newTestServer(t, config{maxLineBytes: 1 << 20})
+	n := maxBatchBytes/(1<<19) + 4
+	var b strings.Builder
+	b.WriteString("KV/1.0 BATCH " + strconv.Itoa(n) + "\n")
+	for i := range n {
+		b.WriteString("KV/1.0 PUT k" + strconv.Itoa(i) + " " + strings.Repeat("v", 1<<19) + "\n")
+	}
+	b.WriteString("KV/1.0 GET k0\n")
+
+	resp, rest := runBatch(t, sv, b.String())
+	lines := strings.Split(strings.TrimSuffix(resp, "\n"), "\n")
+	if len(lines) != n+1 {
+		t.Fatalf("got %d reply lines, want %d", len(lines), n+1)
+	}
+	for _, l := range lines[:n] {
+		if l != "413 PAYLOAD_TOO_LARGE" {
+			t.Fatalf("got %q", l)
+		}
+	}
+	if n, _ := sv.store.size(); n != 0 {
+		t.Errorf("oversized batch stored %d keys", n)
+	}
+	if rest != "KV/1.0 GET k0" {
+		t.Errorf("next request = %q", rest)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cmdInfo describes a command for checks that run before its handler.
+type cmdInfo struct {
+	// keys returns the indexes of args (the tokens after the verb) that
+	// name keys or key prefixes.
+	keys func(args []string) []int
+	// write marks commands that mutate the keyspace.
+	write bool
+}
+
+func firstKey(args []string) []int {
+	if len(args) == 0 {
+		return nil
+	}
+	return []int{0}
+}
+
+// condKeys is PUTIF's layout: <condKey> <condVal> <key> <value>.
+func condKeys(args []string) []int {
+	if len(args) < 3 {
+		return firstKey(args)
+	}
+	return []int{0, 2}
+}
+
+func allKeys(args []string) []int {
+	idx := make([]int, len(args))
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+func noKeys([]string) []int { return nil }
+
+var commands = map[string]cmdInfo{
+	"PUT":         {keys: firstKey, write: true},
+	"GET":         {keys: firstKey},
+	"DEL":         {keys: firstKey, write: true},
+	"GETEXISTING": {keys: allKeys},
+	"GETPREFIX":   {keys: firstKey},
+	"PUTCHANGED":  {keys: firstKey, write: true},
+	"PUTSEQ":      {keys: firstKey, write: true},
+	"GETRANGE":    {keys: firstKey},
+	"SETRANGE":    {keys: firstKey, write: true},
+	"GETRESET":    {keys: firstKey, write: true},
+	"INCRINIT":    {keys: firstKey, write: true},
+	"CADEL":       {keys: firstKey, write: true},
+	"PUTIF":       {keys: condKeys, write: true},
+	"WIPE":        {keys: firstKey, write: true},
+	"BIGKEYS":     {keys: noKeys},
+	"CARDINALITY": {keys: noKeys},
+	"CHECKSUM":    {keys: firstKey},
+	"COUNT":       {keys: firstKey},
+	"HISTORY":     {keys: firstKey},
+	"WARMUP":      {keys: firstKey},
+	"STATS":       {keys: noKeys},
+	"SERVERINFO":  {keys: noKeys},
+	"TIME":        {keys: noKeys},
+	"RESTART":     {keys: noKeys},
+	"DEBUG":       {keys: noKeys},
+	"TRACE":       {keys: noKeys},
+	"QUIET":       {keys: noKeys},
+	"READONLY":    {keys: noKeys},
+	"READWRITE":   {keys: noKeys},
+	"PAUSE":       {keys: noKeys},
+	"UNPAUSE":     {keys: noKeys},
+	"QUIT":        {keys: noKeys},
+	"BATCH":       {keys: noKeys}, // framed in handleConn, never reaches dispatch
+}
+
+// normalizeKeys lowercases every key argument of cmd in place, for
+// -case-insensitive-keys. It runs before handlers check their arity, so
+// indexes past the end of args are skipped rather than trusted.
+func normalizeKeys(cmd string, args []string) {
+	info, ok := commands[cmd]
+	if !ok {
+		return
+	}
+	for _, i := range info.keys(args) {
+		if i < len(args) {
+			args[i] = strings.ToLower(args[i])
+		}
+	}
+}
+
+func isWrite(cmd string) bool {
+	return commands[cmd].write
+}
+
+// renames holds -rename-command OLD=NEW settings. An empty NEW disables
+// OLD outright.
+type renames map[string]string
+
+func (r renames) String() string {
+	parts := make([]string, 0, len(r))
+	for old, nw := range r {
+		parts = append(parts, old+"="+nw)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r renames) Set(v string) error {
+	old, nw, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want OLD=NEW, got %q", v)
+	}
+	old, nw = strings.ToUpper(old), strings.ToUpper(nw)
+	if _, known := commands[old]; !known {
+		return fmt.Errorf("unknown command %q", old)
+	}
+	if strings.ContainsAny(nw, " \t") {
+		return fmt.Errorf("new name %q contains whitespace", nw)
+	}
+	if _, taken := commands[nw]; taken && nw != old {
+		return fmt.Errorf("new name %q is already a command", nw)
+	}
+	for o, n := range r {
+		if nw != "" && n == nw && o != old {
+			return fmt.Errorf("new name %q is already used for %s", nw, o)
+		}
+	}
+	r[old] = nw
+	return nil
+}
+
+// resolve maps the verb a client sent to the command it runs. ok is false
+// for an original verb hidden by -rename-command.
+func (sv *server) resolve(verb string) (cmd string, ok bool) {
+	cmd = strings.ToUpper(verb)
+	if orig, renamed := sv.cmdAlias[cmd]; renamed {
+		return orig, true
+	}
+	return cmd, !sv.cmdHidden[cmd]
+}
+
+// commandAliases turns renames into the lookup dispatch uses: alias maps
+// each new verb to the command it runs, hidden lists original verbs that
+// no longer answer under their own name.
+func commandAliases(r renames) (alias map[string]string, hidden map[string]bool) {
+	alias = make(map[string]string)
+	hidden = make(map[string]bool)
+	for old, nw := range r {
+		hidden[old] = true
+		if nw != "" {
+			alias[nw] = old
+		}
+	}
+	return alias, hidden
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t testing.TB, cfg config) *server {
+	t.Helper()
+	if cfg.backend == "" {
+		cfg.backend = "memory"
+	}
+	if cfg.keysLimit == 0 {
+		cfg.keysLimit = 1000
+	}
+	sv, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sv
+}
+
+// A short PUTIF used to index past its arguments while folding keys and
+// take the whole server down.
+func TestPutIfShortArgsCaseInsensitive(t *testing.T) {
+	sv := newTestServer(t, config{caseInsensitiveKeys: true})
+	for _, line := range []string{"KV/1.0 PUTIF a", "KV/1.0 PUTIF a b"} {
+		if resp, _ := sv.dispatch(&connState{}, line); resp != "400 BAD_REQUEST\n" {
+			t.Errorf("%q: got %q", line, resp)
+		}
+	}
+	resp, _ := sv.dispatch(&connState{}, "KV/1.0 PUTIF A v B x")
+	if resp != "409 CONFLICT\n" {
+		t.Errorf("missing condition key: got %q", resp)
+	}
+	sv.store.put("a", "v")
+	if resp, _ := sv.dispatch(&connState{}, "KV/1.0 PUTIF A v B x"); resp != "200 OK\n" {
+		t.Errorf("folded condition key: got %q", resp)
+	}
+	if v, ok, _ := sv.store.get("b"); !ok || v != "x" {
+		t.Errorf("folded target key: got %q, %v", v, ok)
+	}
+}
+
+// Key folding runs before each handler's arity check, so every command has
+// to survive being sent with too few arguments.
+func TestShortArgsEveryCommand(t *testing.T) {
+	sv := newTestServer(t, config{caseInsensitiveKeys: true})
+	for cmd := range commands {
+		for n := range 3 {
+			line := "KV/1.0 " + cmd
+			for i := range n {
+				line += " A" + string(rune('0'+i))
+			}
+			t.Run(line, func(t *testing.T) {
+				sv.dispatch(&connState{}, line)
+			})
+		}
+	}
+}
+
+func TestNormalizeKeysSkipsOutOfRange(t *testing.T) {
+	commands["TESTWIDE"] = cmdInfo{keys: func([]string) []int { return []int{0, 5} }}
+	defer delete(commands, "TESTWIDE")
+	args := []string{"Foo"}
+	normalizeKeys("TESTWIDE", args)
+	if args[0] != "foo" {
+		t.Errorf("got %q", args[0])
+	}
+}
+
+func TestCaseInsensitiveKeysCollide(t *testing.T) {
+	sv := newTestServer(t, config{caseInsensitiveKeys: true})
+	steps := []struct{ line, want string }{
+		{"KV/1.0 PUT Foo 1", "201 CREATED\n"},
+		{"KV/1.0 PUT FOO 2", "200 OK\n"},
+		{"KV/1.0 GET foo", "200 OK 2\n"},
+		{"KV/1.0 PUT Bar 3", "201 CREATED\n"},
+		{"KV/1.0 GETEXISTING FOO bAR", `200 OK {"bar":"3","foo":"2"}` + "\n"},
+		{"KV/1.0 GETPREFIX F", `200 OK {"items":{"foo":"2"},"truncated":false}` + "\n"},
+		{"KV/1.0 DEL fOO", "204 NO_CONTENT\n"},
+		{"KV/1.0 GET Foo", "404 NOT_FOUND\n"},
+	}
+	for _, s := range steps {
+		if resp, _ := sv.dispatch(&connState{}, s.line); resp != s.want {
+			t.Errorf("%q: got %q, want %q", s.line, resp, s.want)
+		}
+	}
+	if n, _ := sv.store.size(); n != 1 {
+		t.Errorf("size = %d, want 1", n)
+	}
+}
+
+func TestRenamesSet(t *testing.T) {
+	tests := []struct {
+		flags []string
+		ok    bool
+	}{
+		{[]string{"GET=FETCH"}, true},
+		{[]string{"GET="}, true},
+		{[]string{"GET=get"}, true},
+		{[]string{"GET=FETCH", "GET=READ"}, true},
+		{[]string{"GET=", "DEL="}, true},
+		{[]string{"GET"}, false},
+		{[]string{"NOPE=X"}, false},
+		{[]string{"GET=PUT"}, false},
+		{[]string{"GET=FETCH", "DEL=fetch"}, false},
+		{[]string{"BATCH=GROUP"}, true},
+		{[]string{"BATCH="}, true},
+		{[]string{"GET=BATCH"}, false},
+	}
+	for _, tt := range tests {
+		r := renames{}
+		var err error
+		for _, f := range tt.flags {
+			if err = r.Set(f); err != nil {
+				break
+			}
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: err = %v, want ok %v", tt.flags, err, tt.ok)
+		}
+	}
+}
+
+// BATCH is framed before dispatch, so the frame reader has to honour
+// renames too: the new name opens a frame, the old one is unknown.
+func TestRenamedBatch(t *testing.T) {
+	r := renames{}
+	if err := r.Set("BATCH=GROUP"); err != nil {
+		t.Fatal(err)
+	}
+	sv := newTestServer(t, config{maxLineBytes: 1 << 10, renames: r})
+	c, done := serveOne(t, sv)
+	if _, err := io.WriteString(c, "KV/1.0 GROUP 1\nKV/1.0 PUT a 1\nKV/1.0 BATCH 1\nKV/1.0 GET a\nKV/1.0 QUIT\n"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("read: %v (got %q)", err, got)
+	}
+	want := "201 CREATED\n" + `200 OK {"atomic":false,"count":1,"failed":0,"ok":1}` + "\n" +
+		"404 UNKNOWN_COMMAND\n200 OK 1\n200 OK bye\n"
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	waitHandler(t, done)
+
+	// nested under its new name it is still refused
+	resp, _ := runBatch(t, sv, "KV/1.0 GROUP 1\nKV/1.0 GROUP 1\n")
+	if want := "400 BAD_REQUEST\n" + `200 OK {"atomic":false,"count":1,"failed":1,"ok":0}` + "\n"; resp != want {
+		t.Errorf("nested: got %q, want %q", resp, want)
+	}
+}
+
+// trickyValues look like protocol: a version, command names, status codes
+// and a QUIET OFF summary. Values are single tokens, so "200 OK" is covered
+// word by word.
+var trickyValues = []string{"KV/1.0", "QUIT", "quit", "BATCH", "QUIET", "404", "200", "OK", `{"errors":5}`}
+
+// A stored value is data wherever it travels; none of these may be taken
+// for a command or a status.
+func TestTrickyValues(t *testing.T) {
+	sv := newTestServer(t, config{maxLineBytes: 1 << 10})
+	var simpleReqs, simpleWant strings.Builder
+	for i, v := range trickyValues {
+		k := "k" + strconv.Itoa(i)
+		t.Run("dispatch/"+v, func(t *testing.T) {
+			for _, s := range []struct{ line, want string }{
+				{"KV/1.0 PUT " + k + " " + v, "201 CREATED\n"},
+				{"KV/1.0 GET " + k, "200 OK " + v + "\n"},
+			} {
+				if resp, quit := sv.dispatch(&connState{}, s.line); resp != s.want || quit {
+					t.Errorf("%q: got %q, %v, want %q", s.line, resp, quit, s.want)
+				}
+			}
+		})
+		t.Run("batch/"+v, func(t *testing.T) {
+			resp, _ := runBatch(t, sv, "KV/1.0 BATCH 2\nKV/1.0 PUT b"+k+" "+v+"\nKV/1.0 GET b"+k+"\n")
+			want := "201 CREATED\n200 OK " + v + "\n" + `200 OK {"atomic":false,"count":2,"failed":0,"ok":2}` + "\n"
+			if resp != want {
+				t.Errorf("got %q, want %q", resp, want)
+			}
+		})
+		fmt.Fprintf(&simpleReqs, "PUT s%s %s\nGET s%s\n", k, v, k)
+		fmt.Fprintf(&simpleWant, "201 CREATED\n200 OK %s\n", v)
+	}
+
+	t.Run("simple", func(t *testing.T) {
+		c, done := serveConn(t, sv, true)
+		if _, err := io.WriteString(c, simpleReqs.String()+"QUIT\n"); err != nil {
+			t.Fatal(err)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got, err := io.ReadAll(c)
+		if err != nil {
+			t.Fatalf("read: %v (got %q)", err, got)
+		}
+		if want := simpleWant.String() + "200 OK bye\n"; string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		waitHandler(t, done)
+	})
+}
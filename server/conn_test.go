@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveOne runs handleConn for a single loopback connection and returns the
+// client end plus a channel closed when the handler returns.
+func serveOne(t *testing.T, sv *server) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	return serveConn(t, sv, false)
+}
+
+// serveConn is serveOne with a choice of protocol; simple is the
+// version-less one of -simple-addr.
+func serveConn(t *testing.T, sv *server, simple bool) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		sv.handleConn(c, simple)
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, done
+}
+
+func waitHandler(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still running")
+	}
+}
+
+// waitReqs waits until the server has counted n requests, i.e. the
+// handler has read them.
+func waitReqs(t *testing.T, sv *server, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for sv.statsCopy().ReqCount < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("server read %d requests, want %d", sv.statsCopy().ReqCount, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// shutdownServer gives sv a listener to close and shuts it down.
+func shutdownServer(t *testing.T, sv *server) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sv.ln = ln
+	sv.shutdown()
+}
+
+// The goodbye must arrive before EOF even when the client has already sent
+// more requests, which would make a plain Close reset the connection.
+func TestQuitReplyBeforeEOF(t *testing.T) {
+	sv := newTestServer(t, config{maxLineBytes: 1 << 10})
+	c, done := serveOne(t, sv)
+	if _, err := io.WriteString(c, "KV/1.0 PUT a 1\nKV/1.0 QUIT\nKV/1.0 GET a\n"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("read: %v (got %q)", err, got)
+	}
+	if want := "201 CREATED\n200 OK bye\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	waitHandler(t, done)
+}
+
+// A client that hangs up mid-command doesn't cut the command short, and
+// the handler still exits once its reply fails to go out.
+func TestDisconnectDuringCommand(t *testing.T) {
+	sv := newTestServer(t, config{maxLineBytes: 1 << 10, writeTimeout: time.Second})
+	c, done := serveOne(t, sv)
+
+	// stand in for an ATOMIC batch elsewhere: the PUT is read, then waits
+	sv.execMu.Lock()
+	if _, err := io.WriteString(c, "KV/1.0 PUT slow 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitReqs(t, sv, 1)
+	c.Close()
+	sv.execMu.Unlock()
+
+	waitHandler(t, done)
+	if v, ok, _ := sv.store.get("slow"); !ok || v != "1" {
+		t.Errorf("in-flight PUT lost: got %q, %v", v, ok)
+	}
+}
+
+// An idle client must not hold shutdown up for the whole -drain-timeout.
+func TestShutdownClosesIdleConn(t *testing.T) {
+	sv := newTestServer(t, config{maxLineBytes: 1 << 10})
+	c, done := serveOne(t, sv)
+	if _, err := io.WriteString(c, "KV/1.0 PUT a 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitReqs(t, sv, 1)
+	shutdownServer(t, sv)
+	waitHandler(t, done)
+}
+
+// The request in flight at shutdown is answered normally; one pipelined
+// behind it is refused, not applied.
+func TestShutdownRefusesLaterRequests(t *testing.T) {
+	sv := newTestServer(t, config{maxLineBytes: 1 << 10})
+	c, done := serveOne(t, sv)
+
+	sv.execMu.Lock()
+	if _, err := io.WriteString(c, "KV/1.0 PUT a 1\nKV/1.0 PUT b 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitReqs(t, sv, 1)
+	shutdownServer(t, sv)
+	sv.execMu.Unlock()
+
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("read: %v (got %q)", err, got)
+	}
+	if want := "201 CREATED\n503 SHUTTING_DOWN\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	waitHandler(t, done)
+	if _, ok, _ := sv.store.get("b"); ok {
+		t.Error("PUT after shutdown was applied")
+	}
+}
+
+// A peer that stays connected but stops reading must not hold the handler
+// past -write-timeout once the socket buffers fill.
+func TestWriteTimeoutStalledReader(t *testing.T) {
+	const writeTimeout = 200 * time.Millisecond
+	sv := newTestServer(t, config{maxLineBytes: 1 << 10, writeTimeout: writeTimeout})
+	// far more than loopback socket buffers hold
+	sv.store.put("big", strings.Repeat("x", 64<<20))
+	c, done := serveOne(t, sv)
+	_ = c.(*net.TCPConn).SetReadBuffer(4 << 10)
+
+	if _, err := io.WriteString(c, "KV/1.0 GET big\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitReqs(t, sv, 1)
+	start := time.Now()
+	select {
+	case <-done:
+	case <-time.After(writeTimeout + 2*time.Second):
+		t.Fatal("handler still writing to a peer that stopped reading")
+	}
+	if d := time.Since(start); d < writeTimeout/2 {
+		t.Errorf("handler exited after %v, before the write could have timed out", d)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// debug serves DEBUG subcommands, which exist so client developers can
+// exercise their error and reconnect paths on demand. They are only
+// reachable with -enable-debug and must never be enabled in production.
+//
+//	DEBUG ERROR <code>  reply with the given status code
+//	DEBUG DROP          reset the connection partway through the reply
+//	DEBUG JMAP          dump sizes of the server's internal structures
+func (sv *server) debug(cs *connState, args []string) string {
+	if len(args) == 0 {
+		return "400 BAD_REQUEST\n"
+	}
+	switch strings.ToUpper(args[0]) {
+	case "ERROR":
+		if len(args) != 2 {
+			return "400 BAD_REQUEST\n"
+		}
+		code, err := strconv.Atoi(args[1])
+		if err != nil || code < 100 || code > 599 {
+			return "400 BAD_REQUEST\n"
+		}
+		return fmt.Sprintf("%d DEBUG_ERROR\n", code)
+
+	case "DROP":
+		if len(args) != 1 {
+			return "400 BAD_REQUEST\n"
+		}
+		cs.drop = true
+		return "200 OK dropping\n"
+
+	case "JMAP":
+		if len(args) != 1 {
+			return "400 BAD_REQUEST\n"
+		}
+		payload, _ := json.Marshal(sv.jmap())
+		return fmt.Sprintf("200 OK %s\n", payload)
+	}
+	return "400 BAD_REQUEST\n"
+}
+
+// jmap gathers DEBUG JMAP output. The store is a single map behind one
+// lock, so it reports as one shard; backends that can't look inside
+// themselves only contribute the key count.
+func (sv *server) jmap() map[string]any {
+	out := map[string]any{"shards": 1}
+	if n, err := sv.store.size(); err != nil {
+		fmt.Println("store error:", err)
+	} else {
+		out["keys"] = n
+	}
+	if j, ok := sv.store.(interface{ jmap() map[string]any }); ok {
+		out["store"] = j.jmap()
+	}
+	if !sv.cfg.noStats {
+		out["active_conns"] = sv.statsCopy().ActiveConns
+	}
+	return out
+}
+
+func (cs *connState) traceIn(line string) {
+	if cs.trace {
+		fmt.Printf("[trace #%d] <- %q\n", cs.id, redact(line))
+	}
+}
+
+func (cs *connState) traceOut(resp string) {
+	if cs.trace {
+		fmt.Printf("[trace #%d] -> %q\n", cs.id, resp)
+	}
+}
+
+// redact hides the arguments of AUTH so traces never contain passwords.
+// The verb is the first token on the simple listener and the second after
+// the version otherwise.
+func redact(line string) string {
+	toks := strings.Fields(line)
+	for i := 0; i < len(toks) && i < 2; i++ {
+		if strings.EqualFold(toks[i], "AUTH") && i+1 < len(toks) {
+			return strings.Join(toks[:i+1], " ") + " ***"
+		}
+	}
+	return line
+}
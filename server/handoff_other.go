@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func inheritedListener(string) (net.Listener, error) { return nil, nil }
+
+func (sv *server) handoff() error {
+	return errors.New("listener handoff is only supported on unix")
+}
+
+func (sv *server) watchHandoff() {}
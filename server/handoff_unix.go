@@ -0,0 +1,117 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// inheritedListener returns the listener a handoff passed down under
+// fdEnv, or nil if this process was started normally.
+func inheritedListener(fdEnv string) (net.Listener, error) {
+	v := os.Getenv(fdEnv)
+	if v == "" {
+		return nil, nil
+	}
+	_ = os.Unsetenv(fdEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s=%q", fdEnv, v)
+	}
+	f := os.NewFile(uintptr(fd), "kvss-listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// handoff starts a new copy of the server binary with the same arguments,
+// passing it the listening sockets, then closes ours so the new process
+// alone accepts. Connections already open here keep being served
+// until they finish or -drain-timeout passes; run then returns. Their
+// writes get 503 HANDED_OFF, since this store dies with the process.
+//
+// The store is not handed over: without persistence the new process
+// starts with an empty keyspace, so callers refuse a handoff while keys
+// exist unless told to force it.
+func (sv *server) handoff() error {
+	sv.handoffMu.Lock()
+	defer sv.handoffMu.Unlock()
+	if sv.handedOff.Load() {
+		return errors.New("listener already handed off")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+
+	pass := func(ln net.Listener, fdEnv string) error {
+		tl, ok := ln.(*net.TCPListener)
+		if !ok {
+			return errors.New("listener is not TCP")
+		}
+		f, err := tl.File()
+		if err != nil {
+			return err
+		}
+		// ExtraFiles[i] becomes fd 3+i in the child
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", fdEnv, 3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+		return nil
+	}
+	defer func() {
+		for _, f := range cmd.ExtraFiles {
+			_ = f.Close()
+		}
+	}()
+	if err := pass(sv.ln, listenFDEnv); err != nil {
+		return err
+	}
+	if sv.simpleLn != nil {
+		if err := pass(sv.simpleLn, simpleFDEnv); err != nil {
+			return err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() { _ = cmd.Wait() }()
+
+	fmt.Printf("[KVSS] listeners handed to pid %d, draining\n", cmd.Process.Pid)
+	sv.handedOff.Store(true)
+	if sv.simpleLn != nil {
+		_ = sv.simpleLn.Close()
+	}
+	return sv.ln.Close()
+}
+
+// watchHandoff triggers a handoff on SIGUSR2. A signal can't say FORCE, so
+// it only hands off an empty store.
+func (sv *server) watchHandoff() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	go func() {
+		for range ch {
+			n, err := sv.store.size()
+			if err != nil {
+				fmt.Println("handoff refused:", err)
+				continue
+			}
+			if n > 0 {
+				fmt.Printf("handoff refused: %d keys would be lost, use RESTART FORCE\n", n)
+				continue
+			}
+			if err := sv.handoff(); err != nil {
+				fmt.Println("handoff error:", err)
+			}
+		}
+	}()
+}
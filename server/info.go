@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// serverInfo describes what exactly is running, for SERVERINFO. Unlike
+// STATS it never changes while the process lives.
+func (sv *server) serverInfo() map[string]any {
+	info := map[string]any{
+		"protocol":    Version,
+		"module":      "",
+		"build":       "(devel)",
+		"commit":      "",
+		"modified":    false,
+		"go_version":  runtime.Version(),
+		"os":          runtime.GOOS,
+		"arch":        runtime.GOARCH,
+		"pid":         os.Getpid(),
+		"start_time":  sv.statsCopy().StartTime.UTC().Format(time.RFC3339),
+		"config_file": "", // flags only, there is no config file
+		"backend":     sv.cfg.backend,
+		"features":    sv.features(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info["module"] = bi.Main.Path
+		info["build"] = bi.Main.Version
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info["commit"] = s.Value
+			case "vcs.modified":
+				info["modified"] = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// features lists the optional behaviours this process was started with.
+func (sv *server) features() []string {
+	out := []string{}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"simple-addr", sv.cfg.simpleAddr != ""},
+		{"case-insensitive-keys", sv.cfg.caseInsensitiveKeys},
+		{"history", sv.cfg.historyDepth > 0},
+		{"debug", sv.cfg.enableDebug},
+		{"restart", sv.cfg.enableRestart},
+		{"pause", sv.cfg.enablePause},
+		{"statsd", sv.cfg.statsdAddr != ""},
+		{"no-stats", sv.cfg.noStats},
+		{"renamed-commands", len(sv.cfg.renames) > 0},
+	} {
+		if f.on {
+			out = append(out, f.name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lockSampleEvery is how often a lock acquisition is timed. Reading the
+// clock on every Lock would cost more than the contention it measures.
+const lockSampleEvery = 16
+
+// timedRWMutex is a sync.RWMutex that times one in lockSampleEvery
+// acquisitions and keeps running totals of the wait.
+type timedRWMutex struct {
+	mu      sync.RWMutex
+	n       atomic.Uint64
+	samples atomic.Int64
+	totalNs atomic.Int64
+	maxNs   atomic.Int64
+}
+
+func (l *timedRWMutex) Lock() {
+	if l.n.Add(1)%lockSampleEvery != 0 {
+		l.mu.Lock()
+		return
+	}
+	start := time.Now()
+	l.mu.Lock()
+	l.record(time.Since(start))
+}
+
+func (l *timedRWMutex) Unlock() { l.mu.Unlock() }
+
+func (l *timedRWMutex) RLock() {
+	if l.n.Add(1)%lockSampleEvery != 0 {
+		l.mu.RLock()
+		return
+	}
+	start := time.Now()
+	l.mu.RLock()
+	l.record(time.Since(start))
+}
+
+func (l *timedRWMutex) RUnlock() { l.mu.RUnlock() }
+
+func (l *timedRWMutex) record(wait time.Duration) {
+	ns := wait.Nanoseconds()
+	l.samples.Add(1)
+	l.totalNs.Add(ns)
+	for {
+		cur := l.maxNs.Load()
+		if ns <= cur || l.maxNs.CompareAndSwap(cur, ns) {
+			return
+		}
+	}
+}
+
+// waitStats summarizes the sampled waits for STATS.
+func (l *timedRWMutex) waitStats() map[string]any {
+	samples := l.samples.Load()
+	total := l.totalNs.Load()
+	avg := int64(0)
+	if samples > 0 {
+		avg = total / samples
+	}
+	return map[string]any{
+		"sample_every":  lockSampleEvery,
+		"samples":       samples,
+		"total_wait_ns": total,
+		"avg_wait_ns":   avg,
+		"max_wait_ns":   l.maxNs.Load(),
+	}
+}
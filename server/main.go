@@ -3,58 +3,40 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 const (
 	Version     = "KV/1.0"
 	DefaultAddr = "127.0.0.1:5050"
-)
 
-type store struct {
-	mu   sync.RWMutex
-	data map[string]string
-}
+	// maxRangeOffset bounds SETRANGE so a single request can't make the
+	// server allocate an arbitrarily large value.
+	maxRangeOffset = 512 << 20
 
-func newStore() *store {
-	return &store{data: make(map[string]string)}
-}
+	// quitLinger is how long QUIT waits for the client to close its side.
+	quitLinger = time.Second
 
-func (s *store) put(k, v string) (created bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	_, existed := s.data[k]
-	s.data[k] = v
-	return !existed
-}
+	// maxPause caps PAUSE WRITES so writes can't be left off indefinitely.
+	maxPause = time.Hour
 
-func (s *store) get(k string) (string, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	v, ok := s.data[k]
-	return v, ok
-}
-
-func (s *store) del(k string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, ok := s.data[k]; ok {
-		delete(s.data, k)
-		return true
-	}
-	return false
-}
-
-func (s *store) size() int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return len(s.data)
-}
+	// listenFDEnv and simpleFDEnv tell a server started by a handoff which
+	// inherited descriptors are its listening sockets.
+	listenFDEnv = "KVSS_LISTEN_FD"
+	simpleFDEnv = "KVSS_SIMPLE_FD"
+)
 
 type stats struct {
 	StartTime   time.Time
@@ -66,167 +48,982 @@ type stats struct {
 	DelCount    int64
 }
 
+type config struct {
+	network    string
+	addr       string
+	simpleAddr string
+	noDelay    bool
+	noStats    bool
+
+	backend         string
+	initialCapacity int
+	keysLimit       int
+	maxLineBytes    int
+	historyDepth    int
+
+	// caseInsensitiveKeys folds every key to lowercase on the way in, so
+	// "Foo" and "foo" name the same entry. It is a startup-wide mode.
+	caseInsensitiveKeys bool
+
+	enableDebug   bool
+	enableRestart bool
+	enablePause   bool
+	renames       renames
+	drainTimeout  time.Duration
+	writeTimeout  time.Duration
+	shutdownDrain time.Duration
+
+	statsdAddr     string
+	statsdInterval time.Duration
+}
+
 type server struct {
-	addr    string
-	store   *store
+	cfg     config
+	store   backend
 	statsMu sync.Mutex
 	stats   stats
+
+	// execMu is held shared by every command and exclusively by
+	// BATCH ... ATOMIC, so an atomic batch never interleaves with others.
+	execMu sync.RWMutex
+
+	cmdAlias  map[string]string
+	cmdHidden map[string]bool
+
+	ln       net.Listener
+	simpleLn net.Listener // nil unless -simple-addr is set
+	conns    sync.WaitGroup
+
+	// open holds every connection being handled, so shutdown can wake
+	// the ones blocked reading.
+	openMu sync.Mutex
+	open   map[net.Conn]struct{}
+
+	handoffMu sync.Mutex
+	// handedOff is set once another process owns the listeners; writes
+	// here would be lost when this one exits.
+	handedOff atomic.Bool
+
+	shuttingDown atomic.Bool
+
+	nextConnID atomic.Int64
+
+	// pausedUntil is the UnixNano deadline of a PAUSE WRITES, 0 if none.
+	pausedUntil atomic.Int64
 }
 
-func newServer(addr string) *server {
-	return &server{
-		addr:  addr,
-		store: newStore(),
+func newServer(cfg config) (*server, error) {
+	st, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sv := &server{
+		cfg:   cfg,
+		store: st,
 		stats: stats{StartTime: time.Now()},
+		open:  make(map[net.Conn]struct{}),
 	}
+	sv.cmdAlias, sv.cmdHidden = commandAliases(cfg.renames)
+	return sv, nil
 }
 
 func (sv *server) incr(field *int64, delta int64) {
+	if sv.cfg.noStats {
+		return
+	}
 	sv.statsMu.Lock()
 	*field += delta
 	sv.statsMu.Unlock()
 }
 
-func (sv *server) snapshotStats() map[string]any {
+func (sv *server) statsCopy() stats {
 	sv.statsMu.Lock()
 	defer sv.statsMu.Unlock()
-	uptime := time.Since(sv.stats.StartTime).Seconds()
-	return map[string]any{
-		"version":      Version,
-		"uptime_sec":   int(uptime),
-		"total_conns":  sv.stats.TotalConns,
-		"active_conns": sv.stats.ActiveConns,
-		"req_count":    sv.stats.ReqCount,
-		"put_count":    sv.stats.PutCount,
-		"get_count":    sv.stats.GetCount,
-		"del_count":    sv.stats.DelCount,
-		"keys":         sv.store.size(),
+	return sv.stats
+}
+
+// pauseRemaining reports how much longer writes stay paused.
+func (sv *server) pauseRemaining() time.Duration {
+	until := sv.pausedUntil.Load()
+	if until == 0 {
+		return 0
+	}
+	return max(time.Until(time.Unix(0, until)), 0)
+}
+
+func (sv *server) snapshotStats() map[string]any {
+	if sv.cfg.noStats {
+		return map[string]any{
+			"version": Version,
+			"stats":   "disabled",
+		}
+	}
+	st := sv.statsCopy()
+	uptime := time.Since(st.StartTime).Seconds()
+	out := map[string]any{
+		"version":             Version,
+		"uptime_sec":          int(uptime),
+		"total_conns":         st.TotalConns,
+		"active_conns":        st.ActiveConns,
+		"req_count":           st.ReqCount,
+		"put_count":           st.PutCount,
+		"get_count":           st.GetCount,
+		"del_count":           st.DelCount,
+		"writes_paused":       sv.pauseRemaining() > 0,
+		"pause_remaining_sec": int(sv.pauseRemaining().Round(time.Second).Seconds()),
 	}
+	if n, err := sv.store.size(); err != nil {
+		fmt.Println("store error:", err)
+	} else {
+		out["keys"] = n
+	}
+	// only backends that lock in-process can say how long they wait
+	if lw, ok := sv.store.(interface{ lockWaits() map[string]any }); ok {
+		out["locks"] = lw.lockWaits()
+	}
+	return out
 }
 
-func (sv *server) handleConn(c net.Conn) {
+func (sv *server) handleConn(c net.Conn, simple bool) {
 	sv.incr(&sv.stats.TotalConns, 1)
 	sv.incr(&sv.stats.ActiveConns, 1)
+	sv.track(c)
 	defer func() {
+		sv.untrack(c)
 		sv.incr(&sv.stats.ActiveConns, -1)
 		_ = c.Close()
 	}()
 
+	cs := &connState{simple: simple, id: sv.nextConnID.Add(1)}
 	r := bufio.NewReader(c)
 	for {
-		line, err := r.ReadString('\n')
+		line, err := readLine(r, sv.cfg.maxLineBytes)
+		if errors.Is(err, errLineTooLong) {
+			sv.incr(&sv.stats.ReqCount, 1)
+			if cs.quiet {
+				cs.errors++
+			}
+			if err := sv.writeResp(c, "413 PAYLOAD_TOO_LARGE\n"); err != nil {
+				logWriteErr(err)
+				return
+			}
+			continue
+		}
 		if err != nil {
 			// client đóng kết nối
 			return
 		}
-		line = strings.TrimRight(line, "\r\n")
+		cs.traceIn(line)
 		if line == "" {
 			// bỏ qua dòng rỗng
 			continue
 		}
+		if sv.shuttingDown.Load() {
+			// the request in flight at SIGTERM was answered; nothing after it
+			_ = sv.writeResp(c, "503 SHUTTING_DOWN\n")
+			return
+		}
 
 		sv.incr(&sv.stats.ReqCount, 1)
 
-		// Parse: KV/1.0 <CMD> [args...]
-		toks := strings.Fields(line)
-		if len(toks) < 2 {
-			sv.writeResp(c, "400 BAD_REQUEST\n")
-			continue
+		line = cs.versioned(line)
+		var resp string
+		var quit bool
+		wasQuiet := cs.quiet
+		if sv.isBatch(line) {
+			if resp, err = sv.batch(cs, r, line); err != nil {
+				return
+			}
+		} else {
+			sv.execMu.RLock()
+			resp, quit = sv.dispatch(cs, line)
+			sv.execMu.RUnlock()
+		}
+		if cs.drop {
+			abortClose(c, resp)
+			return
 		}
-		if toks[0] != Version {
-			sv.writeResp(c, "426 UPGRADE_REQUIRED\n")
+		if resp = cs.quieted(resp, wasQuiet); resp == "" && !quit {
 			continue
 		}
+		cs.traceOut(resp)
+		if err := sv.writeResp(c, resp); err != nil {
+			logWriteErr(err)
+			return
+		}
+		if quit {
+			lingerClose(c)
+			return
+		}
+	}
+}
 
-		cmd := strings.ToUpper(toks[1])
-		switch cmd {
-		case "PUT":
-			if len(toks) < 4 {
-				sv.writeResp(c, "400 BAD_REQUEST\n")
-				continue
-			}
-			key := toks[2]
-			value := toks[3]
-			created := sv.store.put(key, value)
-			sv.incr(&sv.stats.PutCount, 1)
-			if created {
-				sv.writeResp(c, "201 CREATED\n")
-			} else {
-				sv.writeResp(c, "200 OK\n")
-			}
+var errLineTooLong = errors.New("request line too long")
 
-		case "GET":
-			if len(toks) != 3 {
-				sv.writeResp(c, "400 BAD_REQUEST\n")
-				continue
-			}
-			key := toks[2]
-			if val, ok := sv.store.get(key); ok {
-				sv.incr(&sv.stats.GetCount, 1)
-				sv.writeResp(c, fmt.Sprintf("200 OK %s\n", val))
+// readLine reads one request line without its line ending. bufio.Reader's
+// own buffer is only 4 KiB, so longer lines are assembled from several
+// ReadSlice calls; past limit bytes the rest of the line is discarded
+// without buffering and errLineTooLong is returned once the newline is
+// seen, leaving the reader positioned at the next request.
+func readLine(r *bufio.Reader, limit int) (string, error) {
+	var buf []byte
+	tooLong := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			if len(buf)+len(chunk) > limit {
+				tooLong = true
+				buf = nil
 			} else {
-				sv.writeResp(c, "404 NOT_FOUND\n")
+				buf = append(buf, chunk...)
 			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if tooLong {
+			return "", errLineTooLong
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	}
+}
+
+// lingerClose half-closes c so the last response is followed by a FIN, then
+// drains whatever the client still sends for a short while. Closing with
+// unread input would make the kernel send RST, which can discard the reply
+// before the client reads it.
+func lingerClose(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	}
+	_ = c.SetReadDeadline(time.Now().Add(quitLinger))
+	_, _ = io.Copy(io.Discard, c)
+}
+
+// connState is per-connection state. It is only touched by the goroutine
+// serving that connection, so it needs no locking.
+type connState struct {
+	simple   bool // came in on -simple-addr: requests carry no version
+	drop     bool // DEBUG DROP: abort the connection instead of replying
+	readOnly bool // READONLY: refuse writes on this connection
+	trace    bool // TRACE ON: log every request and response
+	id       int64
+
+	// QUIET ON: drop 2xx response lines, counting what was held back
+	quiet      bool
+	suppressed int
+	errors     int
+}
+
+// versioned adds the version prefix simple-protocol clients leave out, so
+// the rest of the pipeline only ever sees KV/1.0 lines.
+func (cs *connState) versioned(line string) string {
+	if !cs.simple {
+		return line
+	}
+	return Version + " " + line
+}
+
+// quieted strips success lines from resp while QUIET is on, keeping errors
+// so the client can still react. wasQuiet is the mode before the request
+// ran, so the replies to QUIET ON and QUIET OFF themselves always go out.
+func (cs *connState) quieted(resp string, wasQuiet bool) string {
+	if !wasQuiet || !cs.quiet {
+		return resp
+	}
+	var b strings.Builder
+	for _, l := range strings.SplitAfter(resp, "\n") {
+		switch {
+		case l == "":
+		case strings.HasPrefix(l, "2"):
+			cs.suppressed++
+		default:
+			cs.errors++
+			b.WriteString(l)
+		}
+	}
+	return b.String()
+}
+
+// abortClose sends only the first half of resp and sets linger to zero, so
+// the handler's deferred Close resets the connection and the client sees a
+// reply cut off mid-line.
+func abortClose(c net.Conn, resp string) {
+	_, _ = c.Write([]byte(resp[:len(resp)/2]))
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.SetLinger(0)
+	}
+}
+
+// storeFailed logs a backend error and returns the reply for it.
+func storeFailed(err error) (string, bool) {
+	fmt.Println("store error:", err)
+	return "500 SERVER_ERROR\n", false
+}
+
+// dispatch parses one request line and executes it, returning the response
+// to send and whether the connection should be closed afterwards.
+func (sv *server) dispatch(cs *connState, line string) (resp string, quit bool) {
+	// Parse: KV/1.0 <CMD> [args...]
+	toks := strings.Fields(line)
+	if len(toks) < 2 {
+		return "400 BAD_REQUEST\n", false
+	}
+	if toks[0] != Version {
+		return "426 UPGRADE_REQUIRED\n", false
+	}
+
+	cmd, ok := sv.resolve(toks[1])
+	if !ok {
+		return "404 UNKNOWN_COMMAND\n", false
+	}
+	if sv.cfg.caseInsensitiveKeys {
+		normalizeKeys(cmd, toks[2:])
+	}
+	if isWrite(cmd) && cs.readOnly {
+		return "403 READONLY\n", false
+	}
+	if isWrite(cmd) && sv.pauseRemaining() > 0 {
+		return "503 PAUSED\n", false
+	}
+	if isWrite(cmd) && sv.handedOff.Load() {
+		return "503 HANDED_OFF\n", false
+	}
+	switch cmd {
+	case "PUT":
+		if len(toks) < 4 {
+			return "400 BAD_REQUEST\n", false
+		}
+		key := toks[2]
+		value := toks[3]
+		created, err := sv.store.put(key, value)
+		if err != nil {
+			return storeFailed(err)
+		}
+		sv.incr(&sv.stats.PutCount, 1)
+		if created {
+			return "201 CREATED\n", false
+		}
+		return "200 OK\n", false
+
+	case "GET":
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		key := toks[2]
+		val, ok, err := sv.store.get(key)
+		if err != nil {
+			return storeFailed(err)
+		}
+		if ok {
+			sv.incr(&sv.stats.GetCount, 1)
+			return fmt.Sprintf("200 OK %s\n", val), false
+		}
+		return "404 NOT_FOUND\n", false
+
+	case "DEL":
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		key := toks[2]
+		deleted, err := sv.store.del(key)
+		if err != nil {
+			return storeFailed(err)
+		}
+		if deleted {
+			sv.incr(&sv.stats.DelCount, 1)
+			return "204 NO_CONTENT\n", false
+		}
+		return "404 NOT_FOUND\n", false
+
+	case "GETEXISTING":
+		if len(toks) < 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		found, err := sv.store.getMany(toks[2:])
+		if err != nil {
+			return storeFailed(err)
+		}
+		sv.incr(&sv.stats.GetCount, int64(len(found)))
+		payload, _ := json.Marshal(found)
+		return fmt.Sprintf("200 OK %s\n", payload), false
+
+	case "GETPREFIX":
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		items, truncated, err := getPrefix(sv.store, toks[2], sv.cfg.keysLimit)
+		if err != nil {
+			return storeFailed(err)
+		}
+		sv.incr(&sv.stats.GetCount, int64(len(items)))
+		payload, _ := json.Marshal(map[string]any{
+			"items":     items,
+			"truncated": truncated,
+		})
+		return fmt.Sprintf("200 OK %s\n", payload), false
+
+	case "PUTCHANGED":
+		if len(toks) != 4 {
+			return "400 BAD_REQUEST\n", false
+		}
+		changed, err := putChanged(sv.store, toks[2], toks[3])
+		if err != nil {
+			return storeFailed(err)
+		}
+		sv.incr(&sv.stats.PutCount, 1)
+		if changed {
+			return "200 OK 1\n", false
+		}
+		return "200 OK 0\n", false
+
+	case "PUTSEQ":
+		if len(toks) != 4 {
+			return "400 BAD_REQUEST\n", false
+		}
+		key, err := sv.store.putSeq(toks[2], toks[3])
+		if err != nil {
+			return storeFailed(err)
+		}
+		sv.incr(&sv.stats.PutCount, 1)
+		return fmt.Sprintf("201 CREATED %s\n", key), false
+
+	case "GETRANGE":
+		if len(toks) != 5 {
+			return "400 BAD_REQUEST\n", false
+		}
+		start, err1 := strconv.Atoi(toks[3])
+		end, err2 := strconv.Atoi(toks[4])
+		if err1 != nil || err2 != nil {
+			return "400 BAD_REQUEST\n", false
+		}
+		val, ok, err := getRange(sv.store, toks[2], start, end)
+		if err != nil {
+			return storeFailed(err)
+		}
+		if ok {
+			sv.incr(&sv.stats.GetCount, 1)
+			return fmt.Sprintf("200 OK %s\n", val), false
+		}
+		return "404 NOT_FOUND\n", false
 
-		case "DEL":
-			if len(toks) != 3 {
-				sv.writeResp(c, "400 BAD_REQUEST\n")
-				continue
+	case "SETRANGE":
+		if len(toks) != 5 {
+			return "400 BAD_REQUEST\n", false
+		}
+		offset, err := strconv.Atoi(toks[3])
+		if err != nil || offset < 0 || offset > maxRangeOffset {
+			return "400 BAD_REQUEST\n", false
+		}
+		n, err := setRange(sv.store, toks[2], offset, toks[4])
+		if err != nil {
+			return storeFailed(err)
+		}
+		sv.incr(&sv.stats.PutCount, 1)
+		return fmt.Sprintf("200 OK %d\n", n), false
+
+	case "GETRESET":
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		old, ok, err := getReset(sv.store, toks[2])
+		if err != nil {
+			return storeFailed(err)
+		}
+		if !ok {
+			return "422 NOT_AN_INTEGER\n", false
+		}
+		return fmt.Sprintf("200 OK %d\n", old), false
+
+	case "INCRINIT":
+		if len(toks) != 5 {
+			return "400 BAD_REQUEST\n", false
+		}
+		initial, err1 := strconv.ParseInt(toks[3], 10, 64)
+		delta, err2 := strconv.ParseInt(toks[4], 10, 64)
+		if err1 != nil || err2 != nil {
+			return "400 BAD_REQUEST\n", false
+		}
+		n, ok, err := incrInit(sv.store, toks[2], initial, delta)
+		if err != nil {
+			return storeFailed(err)
+		}
+		if !ok {
+			return "422 NOT_AN_INTEGER\n", false
+		}
+		return fmt.Sprintf("200 OK %d\n", n), false
+
+	case "CADEL":
+		if len(toks) != 4 {
+			return "400 BAD_REQUEST\n", false
+		}
+		deleted, existed, err := compareAndDelete(sv.store, toks[2], toks[3])
+		if err != nil {
+			return storeFailed(err)
+		}
+		switch {
+		case deleted:
+			sv.incr(&sv.stats.DelCount, 1)
+			return "204 NO_CONTENT\n", false
+		case existed:
+			return "409 CONFLICT\n", false
+		}
+		return "404 NOT_FOUND\n", false
+
+	case "PUTIF":
+		if len(toks) != 6 {
+			return "400 BAD_REQUEST\n", false
+		}
+		ok, err := sv.store.putIf(toks[2], toks[3], toks[4], toks[5])
+		if err != nil {
+			return storeFailed(err)
+		}
+		if !ok {
+			return "409 CONFLICT\n", false
+		}
+		sv.incr(&sv.stats.PutCount, 1)
+		return "200 OK\n", false
+
+	case "WIPE":
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		wiped, err := sv.store.wipe(toks[2])
+		if err != nil {
+			return storeFailed(err)
+		}
+		if wiped {
+			sv.incr(&sv.stats.DelCount, 1)
+			return "204 NO_CONTENT\n", false
+		}
+		return "404 NOT_FOUND\n", false
+
+	case "BIGKEYS":
+		// O(n) trên toàn bộ keyspace, chỉ dùng khi debug bộ nhớ
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		n, err := strconv.Atoi(toks[2])
+		if err != nil || n < 1 {
+			return "400 BAD_REQUEST\n", false
+		}
+		top, err := bigKeys(sv.store, min(n, sv.cfg.keysLimit))
+		if err != nil {
+			return storeFailed(err)
+		}
+		payload, _ := json.Marshal(top)
+		return fmt.Sprintf("200 OK %s\n", payload), false
+
+	case "CARDINALITY":
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		groups, err := cardinality(sv.store, toks[2])
+		if err != nil {
+			return storeFailed(err)
+		}
+		payload, _ := json.Marshal(groups)
+		return fmt.Sprintf("200 OK %s\n", payload), false
+
+	case "COUNT":
+		// O(n) như GETPREFIX, chỉ có response là nhỏ
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		n, err := count(sv.store, toks[2])
+		if errors.Is(err, path.ErrBadPattern) {
+			return "400 BAD_REQUEST\n", false
+		}
+		if err != nil {
+			return storeFailed(err)
+		}
+		return fmt.Sprintf("200 OK %d\n", n), false
+
+	case "CHECKSUM":
+		if len(toks) > 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		prefix := ""
+		if len(toks) == 3 {
+			prefix = toks[2]
+		}
+		sum, err := checksum(sv.store, prefix)
+		if err != nil {
+			return storeFailed(err)
+		}
+		return fmt.Sprintf("200 OK %s\n", sum), false
+
+	case "HISTORY":
+		if sv.cfg.historyDepth == 0 || len(toks) != 4 {
+			return "400 BAD_REQUEST\n", false
+		}
+		n, err := strconv.Atoi(toks[3])
+		if err != nil || n < 1 {
+			return "400 BAD_REQUEST\n", false
+		}
+		h, ok := sv.store.(historian)
+		if !ok {
+			return "400 BAD_REQUEST\n", false
+		}
+		vals, ok := h.history(toks[2], n)
+		if !ok {
+			return "404 NOT_FOUND\n", false
+		}
+		payload, _ := json.Marshal(vals)
+		return fmt.Sprintf("200 OK %s\n", payload), false
+
+	case "WARMUP":
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		w, ok := sv.store.(warmer)
+		if !ok {
+			// backend thuần bộ nhớ: không có gì để làm nóng
+			return "200 OK\n", false
+		}
+		n, err := w.warmup(toks[2])
+		if err != nil {
+			fmt.Println("warmup error:", err)
+			return "500 SERVER_ERROR\n", false
+		}
+		return fmt.Sprintf("200 OK %d\n", n), false
+
+	case "SERVERINFO":
+		if len(toks) != 2 {
+			return "400 BAD_REQUEST\n", false
+		}
+		payload, _ := json.Marshal(sv.serverInfo())
+		return fmt.Sprintf("200 OK %s\n", payload), false
+
+	case "STATS":
+		if len(toks) != 2 {
+			return "400 BAD_REQUEST\n", false
+		}
+		payload, _ := json.Marshal(sv.snapshotStats())
+		// data trả ra dạng JSON theo sau 200 OK
+		return fmt.Sprintf("200 OK %s\n", string(payload)), false
+
+	case "TIME":
+		if len(toks) != 2 {
+			return "400 BAD_REQUEST\n", false
+		}
+		// giống Redis TIME: giây unix + phần micro giây
+		now := time.Now()
+		return fmt.Sprintf("200 OK %d %d\n", now.Unix(), now.Nanosecond()/1000), false
+
+	case "READONLY", "READWRITE":
+		if len(toks) != 2 {
+			return "400 BAD_REQUEST\n", false
+		}
+		cs.readOnly = cmd == "READONLY"
+		return "200 OK\n", false
+
+	case "PAUSE":
+		if !sv.cfg.enablePause || len(toks) != 4 || strings.ToUpper(toks[2]) != "WRITES" {
+			return "400 BAD_REQUEST\n", false
+		}
+		secs, err := strconv.Atoi(toks[3])
+		if err != nil || secs < 1 || time.Duration(secs)*time.Second > maxPause {
+			return "400 BAD_REQUEST\n", false
+		}
+		sv.pausedUntil.Store(time.Now().Add(time.Duration(secs) * time.Second).UnixNano())
+		return "200 OK\n", false
+
+	case "UNPAUSE":
+		if !sv.cfg.enablePause || len(toks) != 2 {
+			return "400 BAD_REQUEST\n", false
+		}
+		sv.pausedUntil.Store(0)
+		return "200 OK\n", false
+
+	case "RESTART":
+		// RESTART FORCE: chấp nhận process mới bắt đầu với keyspace rỗng
+		force := len(toks) == 3 && strings.ToUpper(toks[2]) == "FORCE"
+		if !sv.cfg.enableRestart || (len(toks) != 2 && !force) {
+			return "400 BAD_REQUEST\n", false
+		}
+		if !force {
+			n, err := sv.store.size()
+			if err != nil {
+				return storeFailed(err)
 			}
-			key := toks[2]
-			if sv.store.del(key) {
-				sv.incr(&sv.stats.DelCount, 1)
-				sv.writeResp(c, "204 NO_CONTENT\n")
-			} else {
-				sv.writeResp(c, "404 NOT_FOUND\n")
+			if n > 0 {
+				return "409 STORE_NOT_EMPTY\n", false
 			}
+		}
+		if err := sv.handoff(); err != nil {
+			fmt.Println("handoff error:", err)
+			return "500 SERVER_ERROR\n", false
+		}
+		return "200 OK restarting\n", false
 
-		case "STATS":
-			if len(toks) != 2 {
-				sv.writeResp(c, "400 BAD_REQUEST\n")
-				continue
-			}
-			payload, _ := json.Marshal(sv.snapshotStats())
-			// data trả ra dạng JSON theo sau 200 OK
-			sv.writeResp(c, fmt.Sprintf("200 OK %s\n", string(payload)))
+	case "TRACE":
+		if !sv.cfg.enableDebug || len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		switch strings.ToUpper(toks[2]) {
+		case "ON":
+			cs.trace = true
+		case "OFF":
+			cs.trace = false
+		default:
+			return "400 BAD_REQUEST\n", false
+		}
+		return "200 OK\n", false
 
-		case "QUIT":
-			sv.writeResp(c, "200 OK bye\n")
-			return
+	case "QUIET":
+		// QUIET OFF reports what was held back since QUIET ON
+		if len(toks) != 3 {
+			return "400 BAD_REQUEST\n", false
+		}
+		switch strings.ToUpper(toks[2]) {
+		case "ON":
+			cs.quiet = true
+			return "200 OK\n", false
+		case "OFF":
+			summary, _ := json.Marshal(map[string]int{"suppressed": cs.suppressed, "errors": cs.errors})
+			cs.quiet, cs.suppressed, cs.errors = false, 0, 0
+			return fmt.Sprintf("200 OK %s\n", summary), false
+		}
+		return "400 BAD_REQUEST\n", false
 
-		default:
-			sv.writeResp(c, "400 BAD_REQUEST\n")
+	case "DEBUG":
+		// chỉ dùng để test client, không bật ở production
+		if !sv.cfg.enableDebug {
+			return "400 BAD_REQUEST\n", false
 		}
+		return sv.debug(cs, toks[2:]), false
+
+	case "QUIT":
+		return "200 OK bye\n", true
+
+	default:
+		return "400 BAD_REQUEST\n", false
 	}
 }
 
-func (sv *server) writeResp(c net.Conn, s string) {
-	_, _ = c.Write([]byte(s))
+// writeResp sends s, giving up after -write-timeout so a peer that vanished
+// without a FIN or RST can't park the handler goroutine forever.
+func (sv *server) writeResp(c net.Conn, s string) error {
+	if sv.cfg.writeTimeout > 0 {
+		_ = c.SetWriteDeadline(time.Now().Add(sv.cfg.writeTimeout))
+	}
+	_, err := c.Write([]byte(s))
+	return err
+}
+
+// logWriteErr reports a failed response write, staying quiet when the
+// client simply went away.
+func logWriteErr(err error) {
+	if !isDisconnect(err) {
+		fmt.Println("write error:", err)
+	}
+}
+
+// isDisconnect reports whether a write error just means the peer went away,
+// which is routine and not worth logging.
+func isDisconnect(err error) bool {
+	return errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// listen takes over the listener named by fdEnv if a handoff passed one,
+// and binds addr otherwise.
+func (sv *server) listen(fdEnv, addr, what string) (net.Listener, error) {
+	ln, err := inheritedListener(fdEnv)
+	if ln != nil || err != nil {
+		if err == nil {
+			fmt.Printf("[KVSS] took over %s listener on %s\n", what, ln.Addr())
+		}
+		return ln, err
+	}
+	ln, err = net.Listen(sv.cfg.network, addr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("[KVSS] %s listening on %s (%s)\n", what, addr, sv.cfg.network)
+	return ln, nil
+}
+
+// drain waits for open connections to finish, up to -drain-timeout. It runs
+// once the listener closes, after a handoff or a shutdown.
+func (sv *server) drain() {
+	done := make(chan struct{})
+	go func() {
+		sv.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(sv.cfg.drainTimeout):
+		fmt.Println("[KVSS] drain timeout, closing remaining connections")
+	}
 }
 
 func (sv *server) run() error {
-	ln, err := net.Listen("tcp", sv.addr)
+	ln, err := sv.listen(listenFDEnv, sv.cfg.addr, "KV/1.0")
 	if err != nil {
 		return err
 	}
-	fmt.Printf("[KVSS] listening on %s\n", sv.addr)
+	sv.ln = ln
+	if sv.cfg.simpleAddr != "" {
+		if sv.simpleLn, err = sv.listen(simpleFDEnv, sv.cfg.simpleAddr, "simple"); err != nil {
+			_ = ln.Close()
+			return err
+		}
+	}
+	sv.watchHandoff()
+	sv.watchShutdown()
+	if sv.cfg.statsdAddr != "" && sv.cfg.noStats {
+		fmt.Println("statsd disabled: -no-stats is set")
+	} else if sv.cfg.statsdAddr != "" {
+		if err := sv.startStatsd(); err != nil {
+			fmt.Println("statsd disabled:", err)
+		}
+	}
+	if sv.simpleLn != nil {
+		go sv.serve(sv.simpleLn, true)
+	}
+	sv.serve(ln, false)
+	sv.drain()
+	return nil
+}
+
+// serve accepts on ln until it is closed. simple marks the version-less
+// protocol of -simple-addr.
+func (sv *server) serve(ln net.Listener, simple bool) {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			fmt.Println("accept error:", err)
 			continue
 		}
-		go sv.handleConn(conn)
+		if sv.shuttingDown.Load() {
+			go refuse(conn)
+			continue
+		}
+		if tc, ok := conn.(*net.TCPConn); ok {
+			_ = tc.SetNoDelay(sv.cfg.noDelay)
+		}
+		sv.conns.Add(1)
+		go func() {
+			defer sv.conns.Done()
+			sv.handleConn(conn, simple)
+		}()
+	}
+}
+
+// validNetwork reports whether n is a stream network accepted by -network.
+func validNetwork(n string) bool {
+	switch n {
+	case "tcp", "tcp4", "tcp6":
+		return true
 	}
+	return false
 }
 
 func main() {
-	addr := DefaultAddr
-	if len(os.Args) > 1 && os.Args[1] != "" {
-		addr = os.Args[1]
+	network := flag.String("network", "tcp", "listen network: tcp, tcp4 or tcp6")
+	// Nagle batches small writes: fewer packets for bulk traffic, but an extra
+	// round-trip delay for request/response, so it stays off by default.
+	noDelay := flag.Bool("tcp-nodelay", true, "disable Nagle's algorithm on accepted connections")
+	simpleAddr := flag.String("simple-addr", "", "also serve a version-less protocol for constrained clients on this address")
+	noStats := flag.Bool("no-stats", false, "skip all stat counting for maximum throughput (STATS reports disabled)")
+	backendName := flag.String("backend", "memory", "storage backend: memory")
+	initialCapacity := flag.Int("initial-capacity", 0, "pre-size the store for this many keys")
+	keysLimit := flag.Int("keys-limit", 1000, "max keys returned by multi-key reads such as GETPREFIX")
+	maxLineBytes := flag.Int("max-line-bytes", 1<<20, "longest request line accepted, newline included; longer lines get 413")
+	historyDepth := flag.Int("history-depth", 0, "keep this many prior values per key for HISTORY (0 disables; costs memory per key)")
+	caseInsensitiveKeys := flag.Bool("case-insensitive-keys", false, "lowercase all keys before storing or looking them up (whole server)")
+	renamed := renames{}
+	flag.Var(renamed, "rename-command", "rename a command, OLD=NEW; empty NEW disables it (repeatable)")
+	writeTimeout := flag.Duration("write-timeout", 30*time.Second, "drop a connection whose response can't be written within this long (0 waits forever)")
+	pidfile := flag.String("pidfile", "", "write the server PID here while running")
+	enableRestart := flag.Bool("enable-restart", false, "allow RESTART to hand the listener to a new process; keys are not carried over, so it is refused while any exist unless RESTART FORCE")
+	enablePause := flag.Bool("enable-pause", false, "allow PAUSE WRITES and UNPAUSE (any client could stall writes for up to an hour)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long open connections may take to finish after a handoff or shutdown")
+	shutdownDrain := flag.Duration("shutdown-drain", 0, "on SIGTERM, keep answering queued connections with 503 for this long (max 1m)")
+	enableDebug := flag.Bool("enable-debug", false, "allow DEBUG commands that fake errors and drop connections (never in production)")
+	statsdAddr := flag.String("statsd-addr", "", "push metrics to this StatsD host:port over UDP (empty disables)")
+	statsdInterval := flag.Duration("statsd-interval", 10*time.Second, "how often to push StatsD metrics")
+	flag.Parse()
+
+	if !validNetwork(*network) {
+		fmt.Println("SERVER_ERROR: invalid -network:", *network)
+		os.Exit(2)
+	}
+	if *statsdInterval <= 0 {
+		fmt.Println("SERVER_ERROR: -statsd-interval must be positive")
+		os.Exit(2)
+	}
+	if *initialCapacity < 0 {
+		fmt.Println("SERVER_ERROR: -initial-capacity must not be negative")
+		os.Exit(2)
+	}
+	if *maxLineBytes < 64 {
+		fmt.Println("SERVER_ERROR: -max-line-bytes must be at least 64")
+		os.Exit(2)
+	}
+	if *shutdownDrain < 0 || *shutdownDrain > maxShutdownDrain {
+		fmt.Println("SERVER_ERROR: -shutdown-drain must be between 0 and", maxShutdownDrain)
+		os.Exit(2)
+	}
+	if *historyDepth < 0 {
+		fmt.Println("SERVER_ERROR: -history-depth must not be negative")
+		os.Exit(2)
+	}
+	if *keysLimit < 1 {
+		fmt.Println("SERVER_ERROR: -keys-limit must be positive")
+		os.Exit(2)
+	}
+	cfg := config{
+		network:             *network,
+		addr:                DefaultAddr,
+		simpleAddr:          *simpleAddr,
+		noDelay:             *noDelay,
+		noStats:             *noStats,
+		backend:             *backendName,
+		initialCapacity:     *initialCapacity,
+		keysLimit:           *keysLimit,
+		maxLineBytes:        *maxLineBytes,
+		historyDepth:        *historyDepth,
+		enableDebug:         *enableDebug,
+		renames:             renamed,
+		enableRestart:       *enableRestart,
+		enablePause:         *enablePause,
+		drainTimeout:        *drainTimeout,
+		writeTimeout:        *writeTimeout,
+		shutdownDrain:       *shutdownDrain,
+		caseInsensitiveKeys: *caseInsensitiveKeys,
+		statsdAddr:          *statsdAddr,
+		statsdInterval:      *statsdInterval,
 	}
-	sv := newServer(addr)
-	if err := sv.run(); err != nil {
+	if flag.NArg() > 0 && flag.Arg(0) != "" {
+		cfg.addr = flag.Arg(0)
+	}
+	sv, err := newServer(cfg)
+	if err != nil {
+		fmt.Println("SERVER_ERROR:", err)
+		os.Exit(2)
+	}
+	if *pidfile != "" {
+		if err := writePidfile(*pidfile); err != nil {
+			fmt.Println("SERVER_ERROR:", err)
+			os.Exit(1)
+		}
+	}
+	err = sv.run()
+	if *pidfile != "" {
+		removePidfile(*pidfile)
+	}
+	if err != nil {
 		fmt.Println("SERVER_ERROR:", err)
 		os.Exit(1)
 	}
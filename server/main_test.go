@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Lines longer than bufio.Reader's 4 KiB buffer must be assembled whole, and
+// lines past the limit skipped without losing the next request.
+func TestReadLineLongerThanBuffer(t *testing.T) {
+	long := "KV/1.0 PUT k " + strings.Repeat("a", 5<<10)
+	huge := "KV/1.0 PUT k " + strings.Repeat("b", 20<<10)
+	r := bufio.NewReader(strings.NewReader(long + "\n" + huge + "\r\nKV/1.0 GET k\r\n"))
+	if r.Size() != 4096 {
+		t.Fatalf("bufio buffer is %d bytes, test assumes 4096", r.Size())
+	}
+	const limit = 8 << 10
+
+	got, err := readLine(r, limit)
+	if err != nil || got != long {
+		t.Fatalf("5 KiB line: got %d bytes, err %v", len(got), err)
+	}
+	if _, err := readLine(r, limit); !errors.Is(err, errLineTooLong) {
+		t.Fatalf("20 KiB line: err = %v, want errLineTooLong", err)
+	}
+	if got, err := readLine(r, limit); err != nil || got != "KV/1.0 GET k" {
+		t.Fatalf("line after oversized: got %q, err %v", got, err)
+	}
+}
+
+// A handoff starts the new process empty, so RESTART must not silently
+// drop existing keys.
+func TestRestartRefusedWithKeys(t *testing.T) {
+	sv := newTestServer(t, config{enableRestart: true})
+	sv.store.put("k", "v")
+	steps := []struct{ line, want string }{
+		{"KV/1.0 RESTART", "409 STORE_NOT_EMPTY\n"},
+		{"KV/1.0 RESTART NOW", "400 BAD_REQUEST\n"},
+	}
+	for _, s := range steps {
+		if resp, _ := sv.dispatch(&connState{}, s.line); resp != s.want {
+			t.Errorf("%q: got %q, want %q", s.line, resp, s.want)
+		}
+	}
+	sv = newTestServer(t, config{})
+	if resp, _ := sv.dispatch(&connState{}, "KV/1.0 RESTART FORCE"); resp != "400 BAD_REQUEST\n" {
+		t.Errorf("RESTART FORCE without -enable-restart: got %q", resp)
+	}
+}
+
+func TestPauseNeedsFlag(t *testing.T) {
+	sv := newTestServer(t, config{})
+	for _, line := range []string{"KV/1.0 PAUSE WRITES 10", "KV/1.0 UNPAUSE"} {
+		if resp, _ := sv.dispatch(&connState{}, line); resp != "400 BAD_REQUEST\n" {
+			t.Errorf("%q without -enable-pause: got %q", line, resp)
+		}
+	}
+	sv = newTestServer(t, config{enablePause: true})
+	steps := []struct{ line, want string }{
+		{"KV/1.0 PAUSE WRITES 10", "200 OK\n"},
+		{"KV/1.0 PUT k v", "503 PAUSED\n"},
+		{"KV/1.0 GET k", "404 NOT_FOUND\n"},
+		{"KV/1.0 UNPAUSE", "200 OK\n"},
+		{"KV/1.0 PUT k v", "201 CREATED\n"},
+	}
+	for _, s := range steps {
+		if resp, _ := sv.dispatch(&connState{}, s.line); resp != s.want {
+			t.Errorf("%q: got %q, want %q", s.line, resp, s.want)
+		}
+	}
+}
+
+var errDisk = errors.New("disk on fire")
+
+// brokenBackend fails every call, like a disk backend whose file went away.
+type brokenBackend struct{}
+
+func (brokenBackend) put(string, string) (bool, error)            { return false, errDisk }
+func (brokenBackend) get(string) (string, bool, error)            { return "", false, errDisk }
+func (brokenBackend) getMany([]string) (map[string]string, error) { return nil, errDisk }
+func (brokenBackend) del(string) (bool, error)                    { return false, errDisk }
+func (brokenBackend) size() (int, error)                          { return 0, errDisk }
+func (brokenBackend) update(string, updateFunc) error             { return errDisk }
+func (brokenBackend) scan(func(string, []byte) bool) error        { return errDisk }
+func (brokenBackend) putIf(_, _, _, _ string) (bool, error)       { return false, errDisk }
+func (brokenBackend) putSeq(string, string) (string, error)       { return "", errDisk }
+func (brokenBackend) wipe(string) (bool, error)                   { return false, errDisk }
+
+func TestBackendErrorsAnswer500(t *testing.T) {
+	sv := newTestServer(t, config{enableRestart: true})
+	sv.store = brokenBackend{}
+	for _, line := range []string{
+		"KV/1.0 PUT k v", "KV/1.0 GET k", "KV/1.0 DEL k", "KV/1.0 GETEXISTING k j",
+		"KV/1.0 GETPREFIX k", "KV/1.0 PUTCHANGED k v", "KV/1.0 PUTSEQ k v",
+		"KV/1.0 GETRANGE k 0 1", "KV/1.0 SETRANGE k 0 v", "KV/1.0 GETRESET k",
+		"KV/1.0 INCRINIT k 0 1", "KV/1.0 CADEL k v", "KV/1.0 PUTIF k v j w",
+		"KV/1.0 WIPE k", "KV/1.0 BIGKEYS 1", "KV/1.0 CARDINALITY :",
+		"KV/1.0 COUNT k*", "KV/1.0 CHECKSUM", "KV/1.0 RESTART",
+	} {
+		if resp, _ := sv.dispatch(&connState{}, line); resp != "500 SERVER_ERROR\n" {
+			t.Errorf("%q: got %q", line, resp)
+		}
+	}
+	if resp, _ := sv.dispatch(&connState{}, "KV/1.0 COUNT ["); resp != "400 BAD_REQUEST\n" {
+		t.Errorf("bad pattern: got %q", resp)
+	}
+}
+
+// Connections left on a handed-off process must not be told their writes
+// succeeded: the keys are gone once it exits.
+func TestWritesRefusedAfterHandoff(t *testing.T) {
+	sv := newTestServer(t, config{})
+	sv.handedOff.Store(true)
+	steps := []struct{ line, want string }{
+		{"KV/1.0 PUT k v", "503 HANDED_OFF\n"},
+		{"KV/1.0 DEL k", "503 HANDED_OFF\n"},
+		{"KV/1.0 GET k", "404 NOT_FOUND\n"},
+	}
+	for _, s := range steps {
+		if resp, _ := sv.dispatch(&connState{}, s.line); resp != s.want {
+			t.Errorf("%q: got %q, want %q", s.line, resp, s.want)
+		}
+	}
+}
+
+// BenchmarkDispatch shows what stat counting costs per request: with
+// several goroutines every counted request contends on statsMu, which
+// -no-stats skips.
+func BenchmarkDispatch(b *testing.B) {
+	for _, noStats := range []bool{false, true} {
+		b.Run("no-stats="+strconv.FormatBool(noStats), func(b *testing.B) {
+			sv := newTestServer(b, config{noStats: noStats})
+			sv.dispatch(&connState{}, "KV/1.0 PUT k v")
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				cs := &connState{}
+				for pb.Next() {
+					sv.dispatch(cs, "KV/1.0 GET k")
+				}
+			})
+		})
+	}
+}
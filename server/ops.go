@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Compound commands built on the backend primitives: read-modify-write
+// ones on update, keyspace walks on scan. A new backend gets all of them by
+// implementing those two. Backend errors are passed back untouched.
+
+// putChanged sets k to v and reports whether that changed anything; a new
+// key always counts as a change.
+func putChanged(b backend, k, v string) (changed bool, err error) {
+	err = b.update(k, func(old []byte, exists bool) ([]byte, updateOp) {
+		if exists && string(old) == v {
+			return nil, updateKeep
+		}
+		changed = true
+		return []byte(v), updateSet
+	})
+	return changed, err
+}
+
+// getReset returns k's integer value and sets it to 0. A missing key reads
+// as 0 and is left absent; ok is false if the value is not an integer.
+func getReset(b backend, k string) (n int64, ok bool, err error) {
+	ok = true
+	err = b.update(k, func(old []byte, exists bool) ([]byte, updateOp) {
+		if !exists {
+			return nil, updateKeep
+		}
+		var perr error
+		if n, perr = strconv.ParseInt(string(old), 10, 64); perr != nil {
+			ok = false
+			return nil, updateKeep
+		}
+		return []byte("0"), updateSet
+	})
+	return n, ok, err
+}
+
+// incrInit sets an absent k to initial, or adds delta to its integer value,
+// and returns the result. ok is false if the value is not an integer or
+// the sum would overflow int64; k is left unchanged then.
+func incrInit(b backend, k string, initial, delta int64) (n int64, ok bool, err error) {
+	err = b.update(k, func(old []byte, exists bool) ([]byte, updateOp) {
+		n = initial
+		if exists {
+			cur, err := strconv.ParseInt(string(old), 10, 64)
+			if err != nil {
+				return nil, updateKeep
+			}
+			n = cur + delta
+			if (delta > 0 && n < cur) || (delta < 0 && n > cur) {
+				return nil, updateKeep
+			}
+		}
+		ok = true
+		return strconv.AppendInt(nil, n, 10), updateSet
+	})
+	return n, ok, err
+}
+
+// compareAndDelete removes k only if its current value is v.
+func compareAndDelete(b backend, k, v string) (deleted, existed bool, err error) {
+	err = b.update(k, func(old []byte, exists bool) ([]byte, updateOp) {
+		existed = exists
+		if !exists || string(old) != v {
+			return nil, updateKeep
+		}
+		deleted = true
+		return nil, updateDelete
+	})
+	return deleted, existed, err
+}
+
+// setRange overwrites k's value from offset onwards, zero-padding if the
+// value is shorter than offset, and returns the new length. The value is
+// rebuilt rather than patched, since update may not modify old.
+func setRange(b backend, k string, offset int, part string) (n int, err error) {
+	err = b.update(k, func(old []byte, _ bool) ([]byte, updateOp) {
+		v := make([]byte, max(len(old), offset+len(part)))
+		copy(v, old)
+		copy(v[offset:], part)
+		n = len(v)
+		return v, updateSet
+	})
+	return n, err
+}
+
+// getRange returns the bytes of k's value between start and end inclusive.
+// Negative indices count from the end and out-of-range ones are clamped,
+// as in Redis GETRANGE.
+func getRange(b backend, k string, start, end int) (string, bool, error) {
+	v, ok, err := b.get(k)
+	if !ok || err != nil {
+		return "", false, err
+	}
+	return byteRange(v, start, end), true, nil
+}
+
+func byteRange(v string, start, end int) string {
+	if start < 0 && end < 0 && start > end {
+		return ""
+	}
+	n := len(v)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end || n == 0 {
+		return ""
+	}
+	return v[start : end+1]
+}
+
+// getPrefix returns up to limit key/value pairs whose key starts with prefix,
+// and whether more matches were left out. It walks the whole keyspace.
+func getPrefix(b backend, prefix string, limit int) (out map[string]string, truncated bool, err error) {
+	out = make(map[string]string)
+	err = b.scan(func(k string, v []byte) bool {
+		if !strings.HasPrefix(k, prefix) {
+			return true
+		}
+		if len(out) == limit {
+			truncated = true
+			return false
+		}
+		out[k] = string(v)
+		return true
+	})
+	return out, truncated, err
+}
+
+// count returns how many keys match the path.Match glob pattern. Only the
+// number travels back, but every key is still visited. A malformed pattern
+// is reported as path.ErrBadPattern.
+func count(b backend, pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	n := 0
+	err := b.scan(func(k string, _ []byte) bool {
+		if ok, _ := path.Match(pattern, k); ok {
+			n++
+		}
+		return true
+	})
+	return n, err
+}
+
+type keySize struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+	Type string `json:"type"`
+}
+
+// sizeHeap is a min-heap on Size, so the smallest of the current top n is
+// the one evicted.
+type sizeHeap []keySize
+
+func (h sizeHeap) Len() int           { return len(h) }
+func (h sizeHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h sizeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *sizeHeap) Push(x any)        { *h = append(*h, x.(keySize)) }
+func (h *sizeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// bigKeys returns the n keys with the largest values, largest first. It
+// visits every key but keeps only n candidates in memory.
+func bigKeys(b backend, n int) ([]keySize, error) {
+	h := make(sizeHeap, 0, n)
+	err := b.scan(func(k string, v []byte) bool {
+		if h.Len() < n {
+			heap.Push(&h, keySize{Key: k, Size: len(v), Type: "string"})
+		} else if len(v) > h[0].Size {
+			h[0] = keySize{Key: k, Size: len(v), Type: "string"}
+			heap.Fix(&h, 0)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]keySize, h.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(&h).(keySize)
+	}
+	return out, nil
+}
+
+// cardinalitySample is how many keys CARDINALITY inspects before it stops
+// and extrapolates.
+const cardinalitySample = 10000
+
+// cardinality counts keys per prefix, the prefix being everything before
+// the first delim (keys without delim fall under ""). Past
+// cardinalitySample keys the counts come from the first keys visited,
+// scaled up to the keyspace size; Go's randomized map order makes that a
+// rough sample rather than the same keys every time.
+func cardinality(b backend, delim string) (map[string]int, error) {
+	groups := make(map[string]int)
+	seen := 0
+	err := b.scan(func(k string, _ []byte) bool {
+		if seen == cardinalitySample {
+			return false
+		}
+		seen++
+		prefix, _, found := strings.Cut(k, delim)
+		if !found {
+			prefix = ""
+		}
+		groups[prefix]++
+		return true
+	})
+	if err != nil || seen < cardinalitySample {
+		return groups, err
+	}
+	total, err := b.size()
+	if err != nil {
+		return nil, err
+	}
+	if seen < total {
+		for p, n := range groups {
+			groups[p] = n * total / seen
+		}
+	}
+	return groups, nil
+}
+
+// checksum hashes every key starting with prefix and its value, in key
+// order, so equal keyspaces give equal sums whatever the map layout. Pairs
+// are copied during the scan and hashed after it; each field is
+// length-prefixed so no two keyspaces encode the same.
+func checksum(b backend, prefix string) (string, error) {
+	type pair struct {
+		k string
+		v []byte
+	}
+	var pairs []pair
+	err := b.scan(func(k string, v []byte) bool {
+		if strings.HasPrefix(k, prefix) {
+			pairs = append(pairs, pair{k, bytes.Clone(v)})
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].k < pairs[j].k })
+	h := sha256.New()
+	var n [8]byte
+	for _, p := range pairs {
+		binary.BigEndian.PutUint64(n[:], uint64(len(p.k)))
+		h.Write(n[:])
+		h.Write([]byte(p.k))
+		binary.BigEndian.PutUint64(n[:], uint64(len(p.v)))
+		h.Write(n[:])
+		h.Write(p.v)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+// Expected values are what Redis GETRANGE returns for "hello".
+func TestByteRangeMatchesRedis(t *testing.T) {
+	tests := []struct {
+		start, end int
+		want       string
+	}{
+		{0, -1, "hello"},
+		{0, 0, "h"},
+		{1, 3, "ell"},
+		{-3, -1, "llo"},
+		{0, -100, "h"},
+		{-100, -100, "h"},
+		{-100, 2, "hel"},
+		{2, 100, "llo"},
+		{-1, -5, ""},
+		{3, 1, ""},
+		{5, 10, ""},
+		{100, 200, ""},
+	}
+	for _, tt := range tests {
+		if got := byteRange("hello", tt.start, tt.end); got != tt.want {
+			t.Errorf("byteRange(hello, %d, %d) = %q, want %q", tt.start, tt.end, got, tt.want)
+		}
+	}
+	if got := byteRange("", 0, -1); got != "" {
+		t.Errorf("empty value: got %q", got)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// writePidfile records our PID at path. An existing file naming a live
+// process other than our parent makes it refuse; the parent exception lets
+// a server started by handoff take over while the old one drains. Files
+// left by a crashed server are overwritten.
+func writePidfile(path string) error {
+	if b, err := os.ReadFile(path); err == nil {
+		pid, perr := strconv.Atoi(string(bytes.TrimSpace(b)))
+		switch {
+		case perr != nil:
+			fmt.Printf("[KVSS] replacing unreadable pidfile %s\n", path)
+		case pid == os.Getppid():
+		case processAlive(pid):
+			return fmt.Errorf("pidfile %s: server already running as pid %d", path, pid)
+		default:
+			fmt.Printf("[KVSS] replacing stale pidfile %s (pid %d)\n", path, pid)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
+
+// removePidfile deletes path if it still names us; after a handoff it
+// belongs to the new process.
+func removePidfile(path string) {
+	b, err := os.ReadFile(path)
+	if err != nil || string(bytes.TrimSpace(b)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	_ = os.Remove(path)
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// processAlive can't probe other processes here, so every pidfile is
+// treated as stale.
+func processAlive(int) bool { return false }
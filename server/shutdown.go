@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// maxShutdownDrain bounds -shutdown-drain so a stop request can't be put
+// off indefinitely.
+const maxShutdownDrain = time.Minute
+
+// watchShutdown starts a graceful shutdown on SIGINT or SIGTERM; a second
+// signal exits immediately.
+func (sv *server) watchShutdown() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ch
+		sv.shutdown()
+		<-ch
+		fmt.Println("[KVSS] forced exit")
+		os.Exit(1)
+	}()
+}
+
+// shutdown stops taking new work. For -shutdown-drain the listener stays
+// open so connections already queued by the kernel are accepted and told
+// 503 SHUTTING_DOWN instead of being reset when it closes. Open
+// connections finish the request they are on, answer any later one with
+// 503 SHUTTING_DOWN and close; idle ones are woken and closed at once.
+// Once the listener is closed, run drains them and returns.
+func (sv *server) shutdown() {
+	fmt.Println("[KVSS] shutting down")
+	sv.shuttingDown.Store(true)
+	sv.openMu.Lock()
+	for c := range sv.open {
+		wake(c)
+	}
+	sv.openMu.Unlock()
+	closeAll := func() {
+		_ = sv.ln.Close()
+		if sv.simpleLn != nil {
+			_ = sv.simpleLn.Close()
+		}
+	}
+	if sv.cfg.shutdownDrain <= 0 {
+		closeAll()
+		return
+	}
+	time.AfterFunc(sv.cfg.shutdownDrain, closeAll)
+}
+
+// track registers c as open. A connection registered after shutdown has
+// swept the set is woken here instead.
+func (sv *server) track(c net.Conn) {
+	sv.openMu.Lock()
+	sv.open[c] = struct{}{}
+	sv.openMu.Unlock()
+	if sv.shuttingDown.Load() {
+		wake(c)
+	}
+}
+
+func (sv *server) untrack(c net.Conn) {
+	sv.openMu.Lock()
+	delete(sv.open, c)
+	sv.openMu.Unlock()
+}
+
+// wake makes a read blocked on c return now. A request already buffered
+// is still read and refused by handleConn.
+func wake(c net.Conn) {
+	_ = c.SetReadDeadline(time.Now())
+}
+
+// refuse answers a connection accepted during the shutdown drain window.
+func refuse(c net.Conn) {
+	_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = c.Write([]byte("503 SHUTTING_DOWN\n"))
+	_ = c.Close()
+}
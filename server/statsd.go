@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const statsdPrefix = "kvss."
+
+// startStatsd pushes the STATS counters to cfg.statsdAddr every
+// cfg.statsdInterval. Counters are sent as deltas since the previous push,
+// key and connection counts as gauges. UDP sends never block request
+// handling; a lost packet just loses one interval.
+func (sv *server) startStatsd() error {
+	conn, err := net.Dial("udp", sv.cfg.statsdAddr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[KVSS] statsd -> %s every %s\n", sv.cfg.statsdAddr, sv.cfg.statsdInterval)
+
+	go func() {
+		defer conn.Close()
+		var last stats
+		t := time.NewTicker(sv.cfg.statsdInterval)
+		defer t.Stop()
+		for range t.C {
+			cur := sv.statsCopy()
+			var b strings.Builder
+			counter := func(name string, now, prev int64) {
+				fmt.Fprintf(&b, "%s%s:%d|c\n", statsdPrefix, name, now-prev)
+			}
+			gauge := func(name string, v int64) {
+				fmt.Fprintf(&b, "%s%s:%d|g\n", statsdPrefix, name, v)
+			}
+			counter("total_conns", cur.TotalConns, last.TotalConns)
+			counter("req_count", cur.ReqCount, last.ReqCount)
+			counter("put_count", cur.PutCount, last.PutCount)
+			counter("get_count", cur.GetCount, last.GetCount)
+			counter("del_count", cur.DelCount, last.DelCount)
+			gauge("active_conns", cur.ActiveConns)
+			if n, err := sv.store.size(); err == nil {
+				gauge("keys", int64(n))
+			}
+			last = cur
+
+			if _, err := conn.Write([]byte(strings.TrimSuffix(b.String(), "\n"))); err != nil {
+				fmt.Println("statsd write error:", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// store keeps values as byte slices it owns, so WIPE can zero them in place.
+type store struct {
+	mu   timedRWMutex
+	data map[string][]byte
+	seqs map[string]uint64 // last number handed out per PUTSEQ prefix
+
+	// hist keeps up to histDepth superseded values per key for HISTORY;
+	// it stays empty when histDepth is 0.
+	hist      map[string]*keyHistory
+	histDepth int
+}
+
+type histEntry struct {
+	value []byte
+	setAt time.Time
+}
+
+type keyHistory struct {
+	setAt time.Time   // when the current value was written
+	prior []histEntry // newest first, at most histDepth
+}
+
+// newStore pre-sizes the map for capacity keys (0 lets it grow on demand),
+// which avoids repeated rehashing while a large dataset is loaded.
+func newStore(capacity, histDepth int) *store {
+	return &store{
+		data:      make(map[string][]byte, capacity),
+		seqs:      make(map[string]uint64),
+		hist:      make(map[string]*keyHistory),
+		histDepth: histDepth,
+	}
+}
+
+// set stores v under k, moving any previous value into k's history.
+// Callers hold the write lock.
+func (s *store) set(k string, v []byte) {
+	if s.histDepth > 0 {
+		h := s.hist[k]
+		if h == nil {
+			h = &keyHistory{}
+			s.hist[k] = h
+		}
+		if old, existed := s.data[k]; existed {
+			h.prior = append(h.prior, histEntry{})
+			copy(h.prior[1:], h.prior)
+			h.prior[0] = histEntry{value: old, setAt: h.setAt}
+			if len(h.prior) > s.histDepth {
+				h.prior = h.prior[:s.histDepth]
+			}
+		}
+		h.setAt = time.Now()
+	}
+	s.data[k] = v
+}
+
+// remove deletes k and its history, zeroing both first if wipe is set.
+// Callers hold the write lock.
+func (s *store) remove(k string, wipe bool) {
+	if wipe {
+		clear(s.data[k])
+		if h := s.hist[k]; h != nil {
+			for _, e := range h.prior {
+				clear(e.value)
+			}
+		}
+	}
+	delete(s.data, k)
+	delete(s.hist, k)
+}
+
+// Being in memory, the store never fails; its error results are always nil.
+
+func (s *store) put(k, v string) (created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, existed := s.data[k]
+	s.set(k, []byte(v))
+	return !existed, nil
+}
+
+func (s *store) get(k string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.data[k]
+	return string(v), ok, nil
+}
+
+// getMany looks up all keys under one read lock and returns only the ones
+// that are present.
+func (s *store) getMany(keys []string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := s.data[k]; ok {
+			out[k] = string(v)
+		}
+	}
+	return out, nil
+}
+
+// update runs fn on k's current value under the write lock and applies
+// what it returns. fn must not keep or modify old.
+func (s *store) update(k string, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.data[k]
+	switch v, op := fn(old, ok); op {
+	case updateSet:
+		s.set(k, v)
+	case updateDelete:
+		if ok {
+			s.remove(k, false)
+		}
+	}
+	return nil
+}
+
+// scan calls fn for each key under the read lock, in map order, until fn
+// returns false. fn must not keep or modify v.
+func (s *store) scan(fn func(k string, v []byte) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for k, v := range s.data {
+		if !fn(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *store) del(k string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[k]; ok {
+		s.remove(k, false)
+		return true, nil
+	}
+	return false, nil
+}
+
+// putSeq stores v under the next free "<prefix>:<n>" key and returns it.
+// Numbers already taken by plain PUTs are skipped so PUTSEQ never overwrites.
+func (s *store) putSeq(prefix, v string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		s.seqs[prefix]++
+		k := prefix + ":" + strconv.FormatUint(s.seqs[prefix], 10)
+		if _, taken := s.data[k]; !taken {
+			s.set(k, []byte(v))
+			return k, nil
+		}
+	}
+}
+
+// putIf sets k to v only if condKey currently holds condVal; a missing
+// condKey fails the condition. condKey and k may be the same key.
+func (s *store) putIf(condKey, condVal, k, v string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.data[condKey]
+	if !ok || string(cur) != condVal {
+		return false, nil
+	}
+	s.set(k, []byte(v))
+	return true, nil
+}
+
+// wipe zeroes the value's bytes, and any HISTORY of it, before deleting the
+// key. Only the store's own copies are cleared: the request line, earlier
+// GET responses and any buffers the runtime has not reused yet may still
+// hold the value.
+func (s *store) wipe(k string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[k]; !ok {
+		return false, nil
+	}
+	s.remove(k, true)
+	return true, nil
+}
+
+type histValue struct {
+	Value string    `json:"value"`
+	SetAt time.Time `json:"set_at"`
+}
+
+// history returns up to n of k's prior values with the time each was set,
+// newest first. ok is false if k does not exist.
+func (s *store) history(k string, n int) (out []histValue, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.data[k]; !ok {
+		return nil, false
+	}
+	out = []histValue{}
+	if h := s.hist[k]; h != nil {
+		for _, e := range h.prior[:min(n, len(h.prior))] {
+			out = append(out, histValue{Value: string(e.value), SetAt: e.setAt})
+		}
+	}
+	return out, true
+}
+
+// entryOverhead is a rough per-entry cost of a map slot plus the string and
+// slice headers, added to key and value bytes in the DEBUG JMAP estimates.
+const entryOverhead = 64
+
+// jmap reports entry counts and approximate memory for each internal map.
+func (s *store) jmap() map[string]any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dataBytes := 0
+	for k, v := range s.data {
+		dataBytes += len(k) + cap(v) + entryOverhead
+	}
+	seqBytes := 0
+	for k := range s.seqs {
+		seqBytes += len(k) + 8 + entryOverhead
+	}
+	histBytes, histValues := 0, 0
+	for k, h := range s.hist {
+		histBytes += len(k) + entryOverhead
+		for _, e := range h.prior {
+			histBytes += cap(e.value) + entryOverhead
+		}
+		histValues += len(h.prior)
+	}
+	return map[string]any{
+		"data":    map[string]int{"entries": len(s.data), "approx_bytes": dataBytes},
+		"seqs":    map[string]int{"entries": len(s.seqs), "approx_bytes": seqBytes},
+		"history": map[string]int{"entries": len(s.hist), "values": histValues, "approx_bytes": histBytes},
+	}
+}
+
+func (s *store) lockWaits() map[string]any {
+	return s.mu.waitStats()
+}
+
+func (s *store) size() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.data), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// benchKeys is the size of the keyspace the benchmarks work over.
+const benchKeys = 1 << 16
+
+func benchStore() (*store, []string) {
+	s := newStore(benchKeys, 0)
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+		s.put(keys[i], "value-"+strconv.Itoa(i))
+	}
+	return s, keys
+}
+
+// worker hands each RunParallel goroutine its own starting offset, so
+// goroutines don't walk the keyspace in lockstep.
+var worker atomic.Int64
+
+func BenchmarkStorePut(b *testing.B) {
+	s, keys := benchStore()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(worker.Add(7919))
+		for pb.Next() {
+			s.put(keys[i%benchKeys], "v")
+			i++
+		}
+	})
+}
+
+func BenchmarkStoreGet(b *testing.B) {
+	s, keys := benchStore()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(worker.Add(7919))
+		for pb.Next() {
+			s.get(keys[i%benchKeys])
+			i++
+		}
+	})
+}
+
+// BenchmarkStoreDel deletes and recreates keys so the keyspace never runs
+// dry; each iteration is one del plus one put.
+func BenchmarkStoreDel(b *testing.B) {
+	s, keys := benchStore()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(worker.Add(7919))
+		for pb.Next() {
+			k := keys[i%benchKeys]
+			s.del(k)
+			s.put(k, "v")
+			i++
+		}
+	})
+}
+
+// benchMixed makes writes percent of operations mutations, a tenth of them
+// dels and the rest puts; everything else is a get.
+func benchMixed(b *testing.B, writes int) {
+	s, keys := benchStore()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(worker.Add(7919))
+		for pb.Next() {
+			k := keys[i%benchKeys]
+			switch op := i % 100; {
+			case op < writes/10:
+				s.del(k)
+			case op < writes:
+				s.put(k, "v")
+			default:
+				s.get(k)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkStoreMixedReadHeavy(b *testing.B)  { benchMixed(b, 10) }
+func BenchmarkStoreMixedWriteHeavy(b *testing.B) { benchMixed(b, 90) }
+
+// BenchmarkStoreLoad fills an empty store with benchKeys keys, as when a
+// dataset is bulk loaded, with and without pre-sizing the map to fit.
+func BenchmarkStoreLoad(b *testing.B) {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	for _, capacity := range []int{0, benchKeys} {
+		b.Run("capacity="+strconv.Itoa(capacity), func(b *testing.B) {
+			for range b.N {
+				s := newStore(capacity, 0)
+				for _, k := range keys {
+					s.put(k, "v")
+				}
+			}
+		})
+	}
+}